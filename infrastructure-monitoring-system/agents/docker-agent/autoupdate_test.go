@@ -0,0 +1,123 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+)
+
+// Test that a digest mismatch triggers the expected Pull->Stop->Remove->
+// Create->Start recreation sequence, while a match is a no-op.
+func TestReconcileContainerImage(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	mockClient := &MockDockerClient{
+		inspectResult: &types.ContainerJSON{
+			ContainerJSONBase: &types.ContainerJSONBase{HostConfig: &container.HostConfig{}},
+			Config:            &container.Config{Image: "nginx:latest"},
+		},
+	}
+	agent := &DockerAgent{
+		config:       &Config{AgentID: "test-agent", ServerURL: server.URL},
+		dockerClient: mockClient,
+		httpClient:   &http.Client{},
+	}
+
+	c := types.Container{ID: "c1", Image: "nginx:latest", Names: []string{"/web"}}
+
+	if err := agent.reconcileContainerImage(context.Background(), c, "sha256:same", "sha256:same", ""); err != nil {
+		t.Fatalf("Expected a matching digest to be a no-op, got: %v", err)
+	}
+	if len(mockClient.callLog) != 0 {
+		t.Errorf("Expected no lifecycle calls for a matching digest, got %v", mockClient.callLog)
+	}
+
+	if err := agent.reconcileContainerImage(context.Background(), c, "sha256:old", "sha256:new", ""); err != nil {
+		t.Fatalf("Expected a mismatched digest to recreate successfully, got: %v", err)
+	}
+
+	expected := []string{"pull", "stop", "remove", "create", "start"}
+	if !reflect.DeepEqual(mockClient.callLog, expected) {
+		t.Errorf("Expected lifecycle call order %v, got %v", expected, mockClient.callLog)
+	}
+}
+
+// Test that recreateContainer refuses to proceed when ContainerInspect
+// returns no Config/HostConfig to recreate from, rather than creating a
+// container with empty settings.
+func TestRecreateContainerRequiresInspectConfig(t *testing.T) {
+	agent := &DockerAgent{
+		config:       &Config{},
+		dockerClient: &MockDockerClient{},
+	}
+
+	if err := agent.recreateContainer(context.Background(), "c1", "web", "nginx:latest", ""); err == nil {
+		t.Error("Expected recreateContainer to fail when inspect has no Config/HostConfig")
+	}
+}
+
+func TestAutoUpdateEligible(t *testing.T) {
+	cases := []struct {
+		name    string
+		labels  map[string]string
+		include []string
+		exclude []string
+		want    bool
+	}{
+		{"no filters", map[string]string{"any": "thing"}, nil, nil, true},
+		{"excluded", map[string]string{"sams.autoupdate": "false"}, nil, []string{"sams.autoupdate=false"}, false},
+		{"included match", map[string]string{"sams.autoupdate": "true"}, []string{"sams.autoupdate=true"}, nil, true},
+		{"included no match", map[string]string{"other": "label"}, []string{"sams.autoupdate=true"}, nil, false},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := autoUpdateEligible(tc.labels, tc.include, tc.exclude); got != tc.want {
+				t.Errorf("autoUpdateEligible(%v, %v, %v) = %v, want %v", tc.labels, tc.include, tc.exclude, got, tc.want)
+			}
+		})
+	}
+}
+
+func TestParseImageReference(t *testing.T) {
+	cases := []struct {
+		image, registry, repository, tag string
+	}{
+		{"nginx", "registry-1.docker.io", "library/nginx", "latest"},
+		{"nginx:1.25", "registry-1.docker.io", "library/nginx", "1.25"},
+		{"myorg/myapp:v2", "registry-1.docker.io", "myorg/myapp", "v2"},
+		{"registry.example.com:5000/team/app:v1", "registry.example.com:5000", "team/app", "v1"},
+		{"localhost/app", "localhost", "app", "latest"},
+	}
+
+	for _, tc := range cases {
+		registry, repository, tag := parseImageReference(tc.image)
+		if registry != tc.registry || repository != tc.repository || tag != tc.tag {
+			t.Errorf("parseImageReference(%q) = (%q, %q, %q), want (%q, %q, %q)",
+				tc.image, registry, repository, tag, tc.registry, tc.repository, tc.tag)
+		}
+	}
+}
+
+func TestParseBearerChallenge(t *testing.T) {
+	challenge := `Bearer realm="https://auth.docker.io/token",service="registry.docker.io",scope="repository:library/nginx:pull"`
+	params := parseBearerChallenge(challenge)
+
+	if params["realm"] != "https://auth.docker.io/token" {
+		t.Errorf("Expected realm to be parsed, got %q", params["realm"])
+	}
+	if params["service"] != "registry.docker.io" {
+		t.Errorf("Expected service to be parsed, got %q", params["service"])
+	}
+	if params["scope"] != "repository:library/nginx:pull" {
+		t.Errorf("Expected scope to be parsed, got %q", params["scope"])
+	}
+}
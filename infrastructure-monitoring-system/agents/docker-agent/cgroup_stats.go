@@ -0,0 +1,79 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+// cgroupStatsCollector reads container resource usage directly from the
+// host's cgroup filesystem instead of the Docker stats API, so collecting
+// stats for many containers doesn't require a streaming HTTP request per
+// container per cycle. The platform-specific reads live in
+// cgroup_stats_linux.go / cgroup_stats_other.go; this file holds the
+// CPU-percent bookkeeping shared by both.
+type cgroupStatsCollector struct {
+	version int // 2 for the unified hierarchy, 1 for legacy per-controller, 0 if unavailable
+
+	mu      sync.Mutex
+	prevCPU map[string]cgroupCPUSample
+}
+
+// cgroupCPUSample is the last CPU usage reading for a container, used to
+// compute a CPU percentage from successive samples.
+type cgroupCPUSample struct {
+	usageNanos int64
+	at         time.Time
+}
+
+// newCgroupStatsCollector detects the host's cgroup version once at
+// construction time.
+func newCgroupStatsCollector() *cgroupStatsCollector {
+	return &cgroupStatsCollector{
+		version: detectCgroupVersion(),
+		prevCPU: make(map[string]cgroupCPUSample),
+	}
+}
+
+// available reports whether this host's cgroups were detected successfully.
+// It's nil-safe so zero-value DockerAgents (as constructed in tests) don't
+// need to wire one up.
+func (c *cgroupStatsCollector) available() bool {
+	return c != nil && c.version != 0
+}
+
+// collect reads CPU, memory and network stats for a single container
+// directly from its cgroup. pid is the container's init process ID, used to
+// read /proc/<pid>/net/dev for network counters; pass 0 to skip network.
+func (c *cgroupStatsCollector) collect(containerID, cgroupParent string, pid int) (cpuPercent float64, memUsage, memLimit, netRx, netTx uint64, err error) {
+	dir := cgroupPath(c.version, cgroupParent, containerID)
+
+	usageNanos, err := readCPUUsageNanos(c.version, dir)
+	if err != nil {
+		return 0, 0, 0, 0, 0, err
+	}
+
+	now := time.Now()
+	c.mu.Lock()
+	prev, hadPrev := c.prevCPU[containerID]
+	c.prevCPU[containerID] = cgroupCPUSample{usageNanos: usageNanos, at: now}
+	c.mu.Unlock()
+
+	if hadPrev {
+		if elapsed := now.Sub(prev.at).Seconds(); elapsed > 0 {
+			cpuPercent = float64(usageNanos-prev.usageNanos) / (elapsed * 1e9) * 100
+		}
+	}
+
+	memUsage, memLimit, err = readMemoryUsage(c.version, dir)
+	if err != nil {
+		return cpuPercent, 0, 0, 0, 0, err
+	}
+
+	if pid > 0 {
+		// Network counters are best-effort: a container that has already
+		// exited its init process shouldn't fail the whole collection.
+		netRx, netTx, _ = readNetDev(pid)
+	}
+
+	return cpuPercent, memUsage, memLimit, netRx, netTx, nil
+}
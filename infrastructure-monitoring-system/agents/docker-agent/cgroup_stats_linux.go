@@ -0,0 +1,133 @@
+//go:build linux
+
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// detectCgroupVersion reports whether the host uses the unified cgroup v2
+// hierarchy (identified by the presence of cgroup.controllers at the mount
+// root) or the legacy per-controller cgroup v1 layout.
+func detectCgroupVersion() int {
+	if _, err := os.Stat("/sys/fs/cgroup/cgroup.controllers"); err == nil {
+		return 2
+	}
+	if _, err := os.Stat("/sys/fs/cgroup/cpu"); err == nil {
+		return 1
+	}
+	return 0
+}
+
+// cgroupPath returns the cgroup directory for a container. cgroupParent is
+// the value reported by ContainerInspect().HostConfig.CgroupParent; when
+// empty, it defaults to the layout dockerd itself uses.
+func cgroupPath(version int, cgroupParent, containerID string) string {
+	if version == 2 {
+		if cgroupParent == "" {
+			return filepath.Join("/sys/fs/cgroup/system.slice", "docker-"+containerID+".scope")
+		}
+		return filepath.Join("/sys/fs/cgroup", cgroupParent, containerID)
+	}
+
+	if cgroupParent == "" {
+		return filepath.Join("/sys/fs/cgroup/cpu,cpuacct/docker", containerID)
+	}
+	return filepath.Join("/sys/fs/cgroup/cpu,cpuacct", cgroupParent, containerID)
+}
+
+// readCPUUsageNanos reads cumulative CPU usage in nanoseconds from
+// cpu.stat's usage_usec (v2) or cpuacct.usage (v1).
+func readCPUUsageNanos(version int, dir string) (int64, error) {
+	if version == 2 {
+		data, err := os.ReadFile(filepath.Join(dir, "cpu.stat"))
+		if err != nil {
+			return 0, err
+		}
+		for _, line := range strings.Split(string(data), "\n") {
+			fields := strings.Fields(line)
+			if len(fields) == 2 && fields[0] == "usage_usec" {
+				usec, err := strconv.ParseInt(fields[1], 10, 64)
+				if err != nil {
+					return 0, err
+				}
+				return usec * 1000, nil
+			}
+		}
+		return 0, fmt.Errorf("usage_usec not found in %s/cpu.stat", dir)
+	}
+
+	data, err := os.ReadFile(filepath.Join(dir, "cpuacct.usage"))
+	if err != nil {
+		return 0, err
+	}
+	return strconv.ParseInt(strings.TrimSpace(string(data)), 10, 64)
+}
+
+// readMemoryUsage reads current usage and limit in bytes from
+// memory.current/memory.max (v2) or memory.usage_in_bytes/memory.limit_in_bytes
+// (v1). An unbounded v2 limit ("max") is reported as 0.
+func readMemoryUsage(version int, dir string) (usage, limit uint64, err error) {
+	usageFile, limitFile := "memory.usage_in_bytes", "memory.limit_in_bytes"
+	if version == 2 {
+		usageFile, limitFile = "memory.current", "memory.max"
+	}
+
+	u, err := os.ReadFile(filepath.Join(dir, usageFile))
+	if err != nil {
+		return 0, 0, err
+	}
+	usage, err = strconv.ParseUint(strings.TrimSpace(string(u)), 10, 64)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	l, err := os.ReadFile(filepath.Join(dir, limitFile))
+	if err != nil {
+		return 0, 0, err
+	}
+	limitStr := strings.TrimSpace(string(l))
+	if limitStr == "max" {
+		return usage, 0, nil
+	}
+	limit, err = strconv.ParseUint(limitStr, 10, 64)
+	return usage, limit, err
+}
+
+// readNetDev reads cumulative rx/tx byte counters for a process's network
+// namespace from /proc/<pid>/net/dev, summed across interfaces other than lo.
+func readNetDev(pid int) (rx, tx uint64, err error) {
+	f, err := os.Open(fmt.Sprintf("/proc/%d/net/dev", pid))
+	if err != nil {
+		return 0, 0, err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if !strings.Contains(line, ":") {
+			continue
+		}
+		parts := strings.SplitN(line, ":", 2)
+		if strings.TrimSpace(parts[0]) == "lo" {
+			continue
+		}
+		fields := strings.Fields(parts[1])
+		if len(fields) < 9 {
+			continue
+		}
+		if v, err := strconv.ParseUint(fields[0], 10, 64); err == nil {
+			rx += v
+		}
+		if v, err := strconv.ParseUint(fields[8], 10, 64); err == nil {
+			tx += v
+		}
+	}
+	return rx, tx, scanner.Err()
+}
@@ -0,0 +1,67 @@
+//go:build linux
+
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// Test parsing of cgroup v2 cpu.stat and memory.current/memory.max files.
+func TestReadCgroupV2Stats(t *testing.T) {
+	dir := t.TempDir()
+	writeFile(t, filepath.Join(dir, "cpu.stat"), "usage_usec 5000000\nuser_usec 3000000\nsystem_usec 2000000\n")
+	writeFile(t, filepath.Join(dir, "memory.current"), "104857600\n")
+	writeFile(t, filepath.Join(dir, "memory.max"), "max\n")
+
+	usageNanos, err := readCPUUsageNanos(2, dir)
+	if err != nil {
+		t.Fatalf("readCPUUsageNanos failed: %v", err)
+	}
+	if usageNanos != 5_000_000_000 {
+		t.Errorf("Expected 5000000000ns, got %d", usageNanos)
+	}
+
+	usage, limit, err := readMemoryUsage(2, dir)
+	if err != nil {
+		t.Fatalf("readMemoryUsage failed: %v", err)
+	}
+	if usage != 104857600 {
+		t.Errorf("Expected usage 104857600, got %d", usage)
+	}
+	if limit != 0 {
+		t.Errorf("Expected limit 0 for unbounded 'max', got %d", limit)
+	}
+}
+
+// Test parsing of cgroup v1 cpuacct.usage and memory.usage_in_bytes/memory.limit_in_bytes.
+func TestReadCgroupV1Stats(t *testing.T) {
+	dir := t.TempDir()
+	writeFile(t, filepath.Join(dir, "cpuacct.usage"), "2500000000\n")
+	writeFile(t, filepath.Join(dir, "memory.usage_in_bytes"), "52428800\n")
+	writeFile(t, filepath.Join(dir, "memory.limit_in_bytes"), "134217728\n")
+
+	usageNanos, err := readCPUUsageNanos(1, dir)
+	if err != nil {
+		t.Fatalf("readCPUUsageNanos failed: %v", err)
+	}
+	if usageNanos != 2_500_000_000 {
+		t.Errorf("Expected 2500000000ns, got %d", usageNanos)
+	}
+
+	usage, limit, err := readMemoryUsage(1, dir)
+	if err != nil {
+		t.Fatalf("readMemoryUsage failed: %v", err)
+	}
+	if usage != 52428800 || limit != 134217728 {
+		t.Errorf("Expected usage=52428800 limit=134217728, got usage=%d limit=%d", usage, limit)
+	}
+}
+
+func writeFile(t *testing.T, path, contents string) {
+	t.Helper()
+	if err := os.WriteFile(path, []byte(contents), 0o644); err != nil {
+		t.Fatalf("failed to write %s: %v", path, err)
+	}
+}
@@ -0,0 +1,24 @@
+//go:build !linux
+
+package main
+
+import "fmt"
+
+// Cgroup filesystems are Linux-specific, so collectContainerStats always
+// falls back to the Docker stats API on other platforms.
+
+func detectCgroupVersion() int { return 0 }
+
+func cgroupPath(version int, cgroupParent, containerID string) string { return "" }
+
+func readCPUUsageNanos(version int, dir string) (int64, error) {
+	return 0, fmt.Errorf("cgroup stats are not supported on this platform")
+}
+
+func readMemoryUsage(version int, dir string) (usage, limit uint64, err error) {
+	return 0, 0, fmt.Errorf("cgroup stats are not supported on this platform")
+}
+
+func readNetDev(pid int) (rx, tx uint64, err error) {
+	return 0, 0, fmt.Errorf("cgroup stats are not supported on this platform")
+}
@@ -0,0 +1,31 @@
+package main
+
+import "testing"
+
+// Test that a zero-value collector (as tests elsewhere construct DockerAgent
+// with) never panics when asked for availability.
+func TestCgroupStatsCollectorAvailable(t *testing.T) {
+	var nilCollector *cgroupStatsCollector
+	if nilCollector.available() {
+		t.Error("Expected nil collector to report unavailable")
+	}
+
+	unavailable := &cgroupStatsCollector{version: 0}
+	if unavailable.available() {
+		t.Error("Expected version 0 to report unavailable")
+	}
+
+	available := &cgroupStatsCollector{version: 2, prevCPU: make(map[string]cgroupCPUSample)}
+	if !available.available() {
+		t.Error("Expected version 2 to report available")
+	}
+}
+
+// Test that collect surfaces the underlying read error instead of panicking
+// when the collector couldn't detect a cgroup version.
+func TestCgroupStatsCollectorCollectUnavailable(t *testing.T) {
+	c := &cgroupStatsCollector{version: 0, prevCPU: make(map[string]cgroupCPUSample)}
+	if _, _, _, _, _, err := c.collect("container1", "", 0); err == nil {
+		t.Error("Expected an error when cgroups are unavailable")
+	}
+}
@@ -0,0 +1,241 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// CommandRequest is implemented by a command's typed request struct, so the
+// dispatcher can reject a malformed payload before it reaches the Docker
+// client. Command types with no entry in validatedCommandTypes skip this
+// step and keep validating their own required fields inline, as they
+// already did before the dispatcher existed.
+type CommandRequest interface {
+	Validate() error
+}
+
+// ContainerStartCmd is the typed shape of a "container_start" command.
+type ContainerStartCmd struct {
+	ContainerID string `json:"container_id"`
+}
+
+func (c *ContainerStartCmd) Validate() error {
+	if c.ContainerID == "" {
+		return fmt.Errorf("container_id is required")
+	}
+	return nil
+}
+
+// ContainerStopCmd is the typed shape of a "container_stop" command.
+type ContainerStopCmd struct {
+	ContainerID string  `json:"container_id"`
+	Timeout     float64 `json:"timeout"`
+}
+
+func (c *ContainerStopCmd) Validate() error {
+	if c.ContainerID == "" {
+		return fmt.Errorf("container_id is required")
+	}
+	return nil
+}
+
+// ImagePullCmd is the typed shape of an "image_pull" command.
+type ImagePullCmd struct {
+	Image    string `json:"image"`
+	StreamID string `json:"stream_id"`
+}
+
+func (c *ImagePullCmd) Validate() error {
+	if c.Image == "" {
+		return fmt.Errorf("image name is required")
+	}
+	return nil
+}
+
+// ContainerCreateCmd is the typed shape of a "container_deploy" command.
+type ContainerCreateCmd struct {
+	Image string `json:"image"`
+	Name  string `json:"name"`
+}
+
+func (c *ContainerCreateCmd) Validate() error {
+	if c.Image == "" {
+		return fmt.Errorf("image name is required")
+	}
+	return nil
+}
+
+// PruneCmd is the typed shape shared by the "*_prune" commands. None of
+// their fields are required, so Validate accepts any values; wrong-typed
+// JSON (e.g. a string where a bool belongs) is rejected earlier, when
+// validateCommand decodes the command into this struct.
+type PruneCmd struct {
+	PruneContainers bool `json:"prune_containers"`
+	PruneImages     bool `json:"prune_images"`
+	PruneVolumes    bool `json:"prune_volumes"`
+	PruneNetworks   bool `json:"prune_networks"`
+}
+
+func (c *PruneCmd) Validate() error {
+	return nil
+}
+
+// validatedCommandTypes maps a command type to a constructor for its typed
+// request struct. Command types not listed here keep using the original
+// untyped map[string]interface{} handlers unchanged.
+var validatedCommandTypes = map[string]func() CommandRequest{
+	"container_start":  func() CommandRequest { return &ContainerStartCmd{} },
+	"container_stop":   func() CommandRequest { return &ContainerStopCmd{} },
+	"image_pull":       func() CommandRequest { return &ImagePullCmd{} },
+	"container_deploy": func() CommandRequest { return &ContainerCreateCmd{} },
+	"system_prune":     func() CommandRequest { return &PruneCmd{} },
+	"containers_prune": func() CommandRequest { return &PruneCmd{} },
+	"images_prune":     func() CommandRequest { return &PruneCmd{} },
+	"volumes_prune":    func() CommandRequest { return &PruneCmd{} },
+	"networks_prune":   func() CommandRequest { return &PruneCmd{} },
+}
+
+// validateCommand decodes raw into commandType's typed request struct and
+// validates it, if commandType has an entry in validatedCommandTypes.
+// Unknown types are left to their existing handlers, which already
+// validate their own required fields.
+func validateCommand(commandType string, raw map[string]interface{}) error {
+	newReq, ok := validatedCommandTypes[commandType]
+	if !ok {
+		return nil
+	}
+
+	data, err := json.Marshal(raw)
+	if err != nil {
+		return fmt.Errorf("failed to encode command: %v", err)
+	}
+
+	req := newReq()
+	if err := json.Unmarshal(data, req); err != nil {
+		return fmt.Errorf("malformed %s command: %v", commandType, err)
+	}
+	return req.Validate()
+}
+
+var idempotencyBucket = []byte("command_results")
+
+// commandIdempotencyCache persists the response produced for each command
+// ID in an on-disk BoltDB store, so a command the server redelivers (e.g.
+// after a dropped ack) returns the cached response instead of re-executing
+// against the Docker client. A nil *commandIdempotencyCache behaves as an
+// always-miss cache, so tests that construct a DockerAgent directly don't
+// need one.
+type commandIdempotencyCache struct {
+	db *bolt.DB
+}
+
+// newCommandIdempotencyCache opens (creating if necessary) the BoltDB file
+// at path and ensures its result bucket exists.
+func newCommandIdempotencyCache(path string) (*commandIdempotencyCache, error) {
+	db, err := bolt.Open(path, 0o600, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open idempotency cache %s: %v", path, err)
+	}
+
+	err = db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(idempotencyBucket)
+		return err
+	})
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to initialize idempotency cache %s: %v", path, err)
+	}
+
+	return &commandIdempotencyCache{db: db}, nil
+}
+
+// get returns the cached response previously stored for commandID by put,
+// if any.
+func (c *commandIdempotencyCache) get(commandID string) (map[string]interface{}, bool, error) {
+	if c == nil || commandID == "" {
+		return nil, false, nil
+	}
+
+	var raw []byte
+	err := c.db.View(func(tx *bolt.Tx) error {
+		if v := tx.Bucket(idempotencyBucket).Get([]byte(commandID)); v != nil {
+			raw = append([]byte(nil), v...)
+		}
+		return nil
+	})
+	if err != nil || raw == nil {
+		return nil, false, err
+	}
+
+	var response map[string]interface{}
+	if err := json.Unmarshal(raw, &response); err != nil {
+		return nil, false, fmt.Errorf("failed to decode cached result for %s: %v", commandID, err)
+	}
+	return response, true, nil
+}
+
+// put stores response under commandID for future get calls.
+func (c *commandIdempotencyCache) put(commandID string, response map[string]interface{}) error {
+	if c == nil || commandID == "" {
+		return nil
+	}
+
+	raw, err := json.Marshal(response)
+	if err != nil {
+		return fmt.Errorf("failed to encode result for %s: %v", commandID, err)
+	}
+
+	return c.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(idempotencyBucket).Put([]byte(commandID), raw)
+	})
+}
+
+// auditEntry is one line of the rolling JSONL audit log recordAudit writes,
+// capturing what a command did regardless of outcome.
+type auditEntry struct {
+	CommandID  string      `json:"cmd_id"`
+	Type       string      `json:"type"`
+	Actor      string      `json:"actor,omitempty"`
+	StartedAt  time.Time   `json:"started_at"`
+	FinishedAt time.Time   `json:"finished_at"`
+	Result     interface{} `json:"result,omitempty"`
+	Error      string      `json:"error,omitempty"`
+}
+
+// recordAudit appends entry to Config.AuditLogPath, if set, and POSTs it to
+// the server for centralized retention. Failures on either side are logged
+// rather than returned, since a lost audit entry shouldn't fail the
+// command it describes.
+func (da *DockerAgent) recordAudit(entry auditEntry) {
+	if da.config.AuditLogPath != "" {
+		if err := appendAuditLine(da.config.AuditLogPath, entry); err != nil {
+			log.Printf("Failed to append audit log entry for %s: %v", entry.CommandID, err)
+		}
+	}
+
+	if err := da.sendToServer("/api/v1/agents/audit-log", entry); err != nil {
+		log.Printf("Failed to send audit log entry for %s: %v", entry.CommandID, err)
+	}
+}
+
+// appendAuditLine appends entry as one JSON line to the file at path,
+// creating it if necessary.
+func appendAuditLine(path string, entry auditEntry) error {
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	line, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(append(line, '\n'))
+	return err
+}
@@ -0,0 +1,196 @@
+package main
+
+import (
+	"container/list"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// nonceCacheSize bounds how many recently-seen nonces are remembered for
+// replay detection. Sized generously above any plausible in-flight command
+// burst within a skew window.
+const nonceCacheSize = 4096
+
+// commandEnvelope wraps a command sent over the command channel (or a body
+// posted via sendToServer) with an Ed25519 signature, so anyone who can
+// merely reach the agent's WebSocket can no longer issue commands without
+// the server's private key. Signature covers
+// sha256(payload||nonce||timestamp).
+type commandEnvelope struct {
+	Payload   json.RawMessage `json:"payload"`
+	Nonce     string          `json:"nonce"`
+	Timestamp int64           `json:"timestamp"`
+	Signature string          `json:"signature"`
+}
+
+// envelopeDigest computes the bytes an envelope's signature is taken over.
+func envelopeDigest(payload json.RawMessage, nonce string, timestamp int64) [32]byte {
+	data := append(append([]byte{}, payload...), []byte(nonce)...)
+	data = append(data, []byte(fmt.Sprintf("%d", timestamp))...)
+	return sha256.Sum256(data)
+}
+
+// signEnvelope wraps payload in a commandEnvelope signed with privKey,
+// generating a fresh random nonce and the current timestamp.
+func signEnvelope(privKey ed25519.PrivateKey, payload json.RawMessage) commandEnvelope {
+	nonceBytes := make([]byte, 16)
+	rand.Read(nonceBytes)
+	nonce := hex.EncodeToString(nonceBytes)
+	timestamp := time.Now().Unix()
+
+	digest := envelopeDigest(payload, nonce, timestamp)
+	signature := ed25519.Sign(privKey, digest[:])
+
+	return commandEnvelope{
+		Payload:   payload,
+		Nonce:     nonce,
+		Timestamp: timestamp,
+		Signature: hex.EncodeToString(signature),
+	}
+}
+
+// envelopeIfConfigured returns payload wrapped in a signed commandEnvelope
+// when da has an agent private key configured, or payload unchanged
+// otherwise, so every Transport's Send can apply the same envelope
+// signing httpTransport.Send has always done.
+func envelopeIfConfigured(da *DockerAgent, payload interface{}) (interface{}, error) {
+	if da.agentPrivKey == nil {
+		return payload, nil
+	}
+
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal payload: %v", err)
+	}
+	return signEnvelope(da.agentPrivKey, data), nil
+}
+
+// verifyInboundCommand validates raw as a signed commandEnvelope when the
+// agent has a server public key configured, returning the unwrapped command.
+// When no server public key is configured, raw is passed through unchanged
+// so the agent keeps working against unsigned command channels (e.g. local
+// development).
+func (da *DockerAgent) verifyInboundCommand(raw map[string]interface{}) (map[string]interface{}, error) {
+	if da.serverPubKey == nil {
+		return raw, nil
+	}
+
+	encoded, err := json.Marshal(raw)
+	if err != nil {
+		return nil, fmt.Errorf("failed to re-encode command envelope: %v", err)
+	}
+
+	var envelope commandEnvelope
+	if err := json.Unmarshal(encoded, &envelope); err != nil {
+		return nil, fmt.Errorf("malformed command envelope: %v", err)
+	}
+
+	signature, err := hex.DecodeString(envelope.Signature)
+	if err != nil {
+		return nil, fmt.Errorf("malformed envelope signature: %v", err)
+	}
+
+	digest := envelopeDigest(envelope.Payload, envelope.Nonce, envelope.Timestamp)
+	if !ed25519.Verify(da.serverPubKey, digest[:], signature) {
+		return nil, fmt.Errorf("envelope signature verification failed")
+	}
+
+	age := time.Since(time.Unix(envelope.Timestamp, 0))
+	if age < 0 {
+		age = -age
+	}
+	if age > da.commandSkew {
+		return nil, fmt.Errorf("envelope timestamp outside allowed skew of %s", da.commandSkew)
+	}
+
+	if !da.seenNonces.addIfNew(envelope.Nonce) {
+		return nil, fmt.Errorf("replayed nonce %q", envelope.Nonce)
+	}
+
+	var command map[string]interface{}
+	if err := json.Unmarshal(envelope.Payload, &command); err != nil {
+		return nil, fmt.Errorf("malformed envelope payload: %v", err)
+	}
+	return command, nil
+}
+
+// loadEnvelopeKeys parses the hex-encoded server public key and agent
+// private key from config, if present. Both are optional; an empty string
+// leaves the corresponding key nil, which disables envelope verification or
+// signing respectively.
+func loadEnvelopeKeys(serverPubKeyHex, agentPrivKeyHex string) (ed25519.PublicKey, ed25519.PrivateKey, error) {
+	var serverPubKey ed25519.PublicKey
+	if serverPubKeyHex != "" {
+		raw, err := hex.DecodeString(serverPubKeyHex)
+		if err != nil {
+			return nil, nil, fmt.Errorf("invalid server_public_key: %v", err)
+		}
+		if len(raw) != ed25519.PublicKeySize {
+			return nil, nil, fmt.Errorf("server_public_key must be %d bytes, got %d", ed25519.PublicKeySize, len(raw))
+		}
+		serverPubKey = ed25519.PublicKey(raw)
+	}
+
+	var agentPrivKey ed25519.PrivateKey
+	if agentPrivKeyHex != "" {
+		raw, err := hex.DecodeString(agentPrivKeyHex)
+		if err != nil {
+			return nil, nil, fmt.Errorf("invalid agent_private_key: %v", err)
+		}
+		if len(raw) != ed25519.PrivateKeySize {
+			return nil, nil, fmt.Errorf("agent_private_key must be %d bytes, got %d", ed25519.PrivateKeySize, len(raw))
+		}
+		agentPrivKey = ed25519.PrivateKey(raw)
+	}
+
+	return serverPubKey, agentPrivKey, nil
+}
+
+// nonceCache is a fixed-capacity LRU of recently-seen envelope nonces, used
+// to reject replayed commands. It's sized to comfortably outlive the
+// configurable clock-skew window a nonce needs to stay valid for.
+type nonceCache struct {
+	mu       sync.Mutex
+	capacity int
+	order    *list.List
+	index    map[string]*list.Element
+}
+
+func newNonceCache(capacity int) *nonceCache {
+	return &nonceCache{
+		capacity: capacity,
+		order:    list.New(),
+		index:    make(map[string]*list.Element),
+	}
+}
+
+// addIfNew records nonce and reports true if it hadn't been seen before, or
+// false if it's a replay.
+func (c *nonceCache) addIfNew(nonce string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if _, exists := c.index[nonce]; exists {
+		return false
+	}
+
+	elem := c.order.PushFront(nonce)
+	c.index[nonce] = elem
+
+	for c.order.Len() > c.capacity {
+		oldest := c.order.Back()
+		if oldest == nil {
+			break
+		}
+		c.order.Remove(oldest)
+		delete(c.index, oldest.Value.(string))
+	}
+
+	return true
+}
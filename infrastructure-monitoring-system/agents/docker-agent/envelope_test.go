@@ -0,0 +1,172 @@
+package main
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func newEnvelopeKeyPair(t *testing.T) (ed25519.PublicKey, ed25519.PrivateKey) {
+	t.Helper()
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("failed to generate Ed25519 key pair: %v", err)
+	}
+	return pub, priv
+}
+
+// Test that a command signed with signEnvelope verifies and unwraps cleanly
+// against the matching public key.
+func TestVerifyInboundCommandRoundTrip(t *testing.T) {
+	pub, priv := newEnvelopeKeyPair(t)
+	agent := &DockerAgent{serverPubKey: pub, commandSkew: 30 * time.Second, seenNonces: newNonceCache(16)}
+
+	payload, _ := json.Marshal(map[string]interface{}{"type": "container_start", "container_id": "abc"})
+	envelope := signEnvelope(priv, payload)
+
+	var raw map[string]interface{}
+	encoded, _ := json.Marshal(envelope)
+	json.Unmarshal(encoded, &raw)
+
+	command, err := agent.verifyInboundCommand(raw)
+	if err != nil {
+		t.Fatalf("Expected valid envelope to verify, got: %v", err)
+	}
+	if command["type"] != "container_start" {
+		t.Errorf("Expected unwrapped command type 'container_start', got %v", command["type"])
+	}
+}
+
+// Test that a replayed nonce is rejected on the second delivery.
+func TestVerifyInboundCommandRejectsReplay(t *testing.T) {
+	pub, priv := newEnvelopeKeyPair(t)
+	agent := &DockerAgent{serverPubKey: pub, commandSkew: 30 * time.Second, seenNonces: newNonceCache(16)}
+
+	payload, _ := json.Marshal(map[string]interface{}{"type": "container_list"})
+	envelope := signEnvelope(priv, payload)
+	var raw map[string]interface{}
+	encoded, _ := json.Marshal(envelope)
+	json.Unmarshal(encoded, &raw)
+
+	if _, err := agent.verifyInboundCommand(raw); err != nil {
+		t.Fatalf("Expected first delivery to verify, got: %v", err)
+	}
+	if _, err := agent.verifyInboundCommand(raw); err == nil {
+		t.Error("Expected replayed nonce to be rejected")
+	}
+}
+
+// Test that an envelope older than the configured skew is rejected.
+func TestVerifyInboundCommandRejectsStaleTimestamp(t *testing.T) {
+	pub, priv := newEnvelopeKeyPair(t)
+	agent := &DockerAgent{serverPubKey: pub, commandSkew: 30 * time.Second, seenNonces: newNonceCache(16)}
+
+	payload, _ := json.Marshal(map[string]interface{}{"type": "container_list"})
+	envelope := signEnvelope(priv, payload)
+	envelope.Timestamp = time.Now().Add(-time.Hour).Unix()
+
+	digest := envelopeDigest(envelope.Payload, envelope.Nonce, envelope.Timestamp)
+	envelope.Signature = hex.EncodeToString(ed25519.Sign(priv, digest[:]))
+
+	var raw map[string]interface{}
+	encoded, _ := json.Marshal(envelope)
+	json.Unmarshal(encoded, &raw)
+
+	if _, err := agent.verifyInboundCommand(raw); err == nil {
+		t.Error("Expected a stale envelope to be rejected")
+	}
+}
+
+// Test that a tampered payload fails signature verification.
+func TestVerifyInboundCommandRejectsTamperedPayload(t *testing.T) {
+	pub, priv := newEnvelopeKeyPair(t)
+	agent := &DockerAgent{serverPubKey: pub, commandSkew: 30 * time.Second, seenNonces: newNonceCache(16)}
+
+	payload, _ := json.Marshal(map[string]interface{}{"type": "container_list"})
+	envelope := signEnvelope(priv, payload)
+	envelope.Payload = json.RawMessage(`{"type":"container_remove"}`)
+
+	var raw map[string]interface{}
+	encoded, _ := json.Marshal(envelope)
+	json.Unmarshal(encoded, &raw)
+
+	if _, err := agent.verifyInboundCommand(raw); err == nil {
+		t.Error("Expected a tampered payload to fail signature verification")
+	}
+}
+
+// Test that commands pass through unchanged when no server public key is
+// configured, so unsigned command channels keep working.
+func TestVerifyInboundCommandPassthroughWhenUnconfigured(t *testing.T) {
+	agent := &DockerAgent{}
+	raw := map[string]interface{}{"type": "container_list"}
+
+	command, err := agent.verifyInboundCommand(raw)
+	if err != nil {
+		t.Fatalf("Expected passthrough with no server key configured, got: %v", err)
+	}
+	if command["type"] != "container_list" {
+		t.Errorf("Expected command to pass through unchanged, got %v", command)
+	}
+}
+
+// Test that sendToServer wraps its body in a signed envelope when the agent
+// has a private key configured.
+func TestSendToServerSignsWhenConfigured(t *testing.T) {
+	pub, priv := newEnvelopeKeyPair(t)
+
+	var received commandEnvelope
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewDecoder(r.Body).Decode(&received)
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	agent := &DockerAgent{
+		config:       &Config{AgentID: "test-agent", ServerURL: server.URL},
+		httpClient:   &http.Client{},
+		agentPrivKey: priv,
+	}
+
+	if err := agent.sendToServer("/api/test", map[string]interface{}{"status": "ok"}); err != nil {
+		t.Fatalf("Failed to send signed data to server: %v", err)
+	}
+
+	signature, err := hex.DecodeString(received.Signature)
+	if err != nil {
+		t.Fatalf("Expected a hex-encoded signature, got: %v", err)
+	}
+	digest := envelopeDigest(received.Payload, received.Nonce, received.Timestamp)
+	if !ed25519.Verify(pub, digest[:], signature) {
+		t.Error("Expected the server to be able to verify the signed outgoing envelope")
+	}
+}
+
+// Test that the nonce cache evicts its oldest entries once over capacity.
+func TestNonceCacheEviction(t *testing.T) {
+	cache := newNonceCache(2)
+	if !cache.addIfNew("a") || !cache.addIfNew("b") || !cache.addIfNew("c") {
+		t.Fatal("Expected all first-seen nonces to be accepted")
+	}
+	if !cache.addIfNew("a") {
+		t.Error("Expected the evicted nonce 'a' to be treated as new again")
+	}
+}
+
+// Test that buildClientTLSConfig is a no-op when no TLS paths are set, and
+// errors when only some of cert/key/CA are provided.
+func TestBuildClientTLSConfig(t *testing.T) {
+	cfg, err := buildClientTLSConfig("", "", "")
+	if err != nil || cfg != nil {
+		t.Errorf("Expected no TLS config when nothing is set, got %v, %v", cfg, err)
+	}
+
+	if _, err := buildClientTLSConfig("cert.pem", "", ""); err == nil {
+		t.Error("Expected an error when only tls_cert_file is set")
+	}
+}
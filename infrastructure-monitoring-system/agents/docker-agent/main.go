@@ -3,30 +3,198 @@ package main
 import (
 	"bytes"
 	"context"
+	"crypto/ed25519"
+	"encoding/base64"
 	"encoding/json"
 	"fmt"
 	"io"
 	"log"
 	"net/http"
 	"os"
+	"os/exec"
 	"os/signal"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"syscall"
 	"time"
 
+	"github.com/containerd/containerd/platforms"
 	"github.com/docker/docker/api/types"
 	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/events"
 	"github.com/docker/docker/api/types/filters"
+	"github.com/docker/docker/api/types/network"
+	"github.com/docker/docker/api/types/volume"
 	"github.com/docker/docker/client"
+	"github.com/docker/docker/pkg/stdcopy"
 	"github.com/docker/go-connections/nat"
+	"github.com/gorilla/websocket"
+	specs "github.com/opencontainers/image-spec/specs-go/v1"
 )
 
 // Config represents the agent configuration
 type Config struct {
-	ServerURL          string `json:"server_url"`
-	APIKey            string `json:"api_key"`
-	AgentID           string `json:"agent_id"`
-	CollectionInterval int    `json:"collection_interval"`
-	LogLevel          string `json:"log_level"`
+	ServerURL       string            `json:"server_url"`
+	APIKey          string            `json:"api_key"`
+	AgentID         string            `json:"agent_id"`
+	MetricsInterval int               `json:"metrics_interval"`
+	DockerSocket    string            `json:"docker_socket"`
+	LogLevel        string            `json:"log_level"`
+	EventFilters    EventFilterConfig `json:"event_filters"`
+	// ComposeReplicas maps "project/service" to the number of replicas that
+	// service is expected to run, so checkComposeReplicas can alert when a
+	// project is under-replicated.
+	ComposeReplicas map[string]int `json:"compose_replicas"`
+	// MaxConcurrentStreams caps how many log-follow and exec sessions the
+	// agent will service at once, so a runaway stream can't exhaust file
+	// descriptors. Defaults to 10 when unset.
+	MaxConcurrentStreams int `json:"max_concurrent_streams"`
+	// MetricsBindAddr is the address the local Prometheus/OpenMetrics
+	// endpoint listens on (e.g. ":9323"). Leave empty to disable it.
+	MetricsBindAddr string `json:"metrics_bind_addr"`
+	// StatsBackend selects where collectContainerStats reads per-container
+	// resource usage from: "api" always uses the Docker stats API, "cgroup"
+	// always reads the host's cgroup filesystem directly (falling back to
+	// the API on failure), and "auto" prefers cgroup when available.
+	StatsBackend string `json:"stats_backend"`
+	// PullTimeoutSeconds bounds how long a single image pull may run before
+	// it's cancelled. Defaults to 600 when unset.
+	PullTimeoutSeconds int `json:"pull_timeout_seconds"`
+	// CredentialStore lets a pull command reference registry credentials by
+	// ID (registry_auth.credential_id) instead of the server sending raw
+	// secrets on every command.
+	CredentialStore map[string]RegistryCredential `json:"credential_store"`
+	// ServerPublicKeyHex is the SAMS server's Ed25519 public key, hex-encoded.
+	// When set, every inbound command must arrive wrapped in a signed
+	// envelope (see envelope.go) verified against this key; when empty, the
+	// agent accepts plain unsigned commands, e.g. for local development.
+	ServerPublicKeyHex string `json:"server_public_key"`
+	// AgentPrivateKeyHex is this agent's own Ed25519 private key, hex-encoded.
+	// When set, outgoing sendToServer bodies are wrapped in a signed envelope
+	// the server can verify. Should be paired with ServerPublicKeyHex.
+	AgentPrivateKeyHex string `json:"agent_private_key"`
+	// CommandSkewSeconds bounds how old a signed command envelope's
+	// timestamp may be before it's rejected as stale. Defaults to 30 when
+	// unset. Only consulted when ServerPublicKeyHex is set.
+	CommandSkewSeconds int `json:"command_skew_seconds"`
+	// TLSCertFile, TLSKeyFile and TLSCAFile configure mutual TLS for
+	// httpClient's connection to the SAMS server. All three must be set to
+	// enable mTLS; leaving them empty keeps the plain http.Client behavior.
+	TLSCertFile string `json:"tls_cert_file"`
+	TLSKeyFile  string `json:"tls_key_file"`
+	TLSCAFile   string `json:"tls_ca_file"`
+	// TrustedImageSigningKeys maps a key ID to a hex-encoded Ed25519 public
+	// key trusted to sign image digests. When set, deployContainer refuses
+	// to start any image whose digest isn't signed by one of these keys.
+	TrustedImageSigningKeys map[string]string `json:"trusted_image_signing_keys"`
+	// ImageSignatureSource locates detached image signatures: an
+	// "http(s)://" base URL queried as "<source>/<digest>.sig", or a local
+	// directory containing "<digest>.sig" files. Required when
+	// TrustedImageSigningKeys is set.
+	ImageSignatureSource string `json:"image_signature_source"`
+	// AutoUpdate opts the agent into a Watchtower-style loop that recreates
+	// containers whose running image has drifted from the registry. See
+	// AutoUpdateConfig.
+	AutoUpdate AutoUpdateConfig `json:"auto_update"`
+	// Transport selects how the agent talks to the SAMS server: "http"
+	// (the default) POSTs JSON and uses a WebSocket for command dispatch,
+	// "grpc" uses a gRPC client connection, and "nats" publishes to and
+	// subscribes from a NATS server. See transport.go.
+	Transport string `json:"transport"`
+	// GRPCServerAddr overrides ServerURL as the dial target when
+	// Transport is "grpc" (e.g. "sams-server:9443"), since a gRPC target
+	// isn't an "http(s)://" URL.
+	GRPCServerAddr string `json:"grpc_server_addr"`
+	// NATSServerURL is the NATS server to connect to when Transport is
+	// "nats" (e.g. "nats://localhost:4222").
+	NATSServerURL string `json:"nats_server_url"`
+	// IdempotencyCachePath is the BoltDB file executeCommand uses to record
+	// each command ID's response, so a redelivered command returns the
+	// cached response instead of re-executing. Leave empty to disable
+	// idempotency caching (every command executes, even if repeated).
+	IdempotencyCachePath string `json:"idempotency_cache_path"`
+	// AuditLogPath is the rolling JSONL file executeCommand appends one
+	// auditEntry to per command, in addition to POSTing it to the server.
+	// Leave empty to skip the local file and only POST.
+	AuditLogPath string `json:"audit_log_path"`
+}
+
+// RegistryCredential holds registry login credentials resolvable via
+// Config.CredentialStore.
+type RegistryCredential struct {
+	Username      string `json:"username"`
+	Password      string `json:"password"`
+	IdentityToken string `json:"identity_token"`
+}
+
+// Stats backend modes for Config.StatsBackend.
+const (
+	statsBackendAPI    = "api"
+	statsBackendCgroup = "cgroup"
+	statsBackendAuto   = "auto"
+)
+
+const (
+	// statsStreamSamples is how many JSON frames collectContainerStatsFromAPI
+	// reads from a streaming ContainerStats response per collection cycle.
+	statsStreamSamples = 2
+
+	// statsHistoryWindow is how many past collection cycles' samples are
+	// kept per container for sustained-threshold health checks.
+	statsHistoryWindow = 5
+
+	// sustainedAlertSamples is how many of the last statsHistoryWindow
+	// samples must breach a threshold before performHealthChecks alerts,
+	// so a single instantaneous spike doesn't cause alert flapping.
+	sustainedAlertSamples = 3
+
+	highCPUThreshold = 80.0
+)
+
+// EventFilterConfig controls which Docker events are forwarded as alerts, so a
+// busy host doesn't flood the server with noise from every lifecycle event.
+type EventFilterConfig struct {
+	OnlyNonZeroExitDie bool     `json:"only_nonzero_exit_die"`
+	LabelSelectors     []string `json:"label_selectors"`
+}
+
+// DockerClient is the subset of the Docker Engine API the agent depends on.
+// Abstracting it behind an interface lets tests exercise agent logic against
+// MockDockerClient instead of a real daemon.
+type DockerClient interface {
+	ContainerList(ctx context.Context, options types.ContainerListOptions) ([]types.Container, error)
+	ContainerStats(ctx context.Context, containerID string, stream bool) (types.ContainerStats, error)
+	Info(ctx context.Context) (types.Info, error)
+	ServerVersion(ctx context.Context) (types.Version, error)
+	ImageList(ctx context.Context, options types.ImageListOptions) ([]types.ImageSummary, error)
+	VolumeList(ctx context.Context, options volume.ListOptions) (volume.ListResponse, error)
+	NetworkList(ctx context.Context, options types.NetworkListOptions) ([]types.NetworkResource, error)
+	Ping(ctx context.Context) (types.Ping, error)
+	DiskUsage(ctx context.Context, options types.DiskUsageOptions) (types.DiskUsage, error)
+	ContainerInspect(ctx context.Context, containerID string) (types.ContainerJSON, error)
+	ContainerStart(ctx context.Context, containerID string, options types.ContainerStartOptions) error
+	ContainerStop(ctx context.Context, containerID string, options container.StopOptions) error
+	ContainerRestart(ctx context.Context, containerID string, options container.StopOptions) error
+	ContainerRemove(ctx context.Context, containerID string, options types.ContainerRemoveOptions) error
+	ImagePull(ctx context.Context, refStr string, options types.ImagePullOptions) (io.ReadCloser, error)
+	ImageRemove(ctx context.Context, imageID string, options types.ImageRemoveOptions) ([]types.ImageDeleteResponseItem, error)
+	ContainerLogs(ctx context.Context, container string, options types.ContainerLogsOptions) (io.ReadCloser, error)
+	ContainersPrune(ctx context.Context, pruneFilters filters.Args) (types.ContainersPruneReport, error)
+	ImagesPrune(ctx context.Context, pruneFilters filters.Args) (types.ImagesPruneReport, error)
+	VolumesPrune(ctx context.Context, pruneFilters filters.Args) (types.VolumesPruneReport, error)
+	NetworksPrune(ctx context.Context, pruneFilters filters.Args) (types.NetworksPruneReport, error)
+	ContainerCreate(ctx context.Context, config *container.Config, hostConfig *container.HostConfig, networkingConfig *network.NetworkingConfig, platform *specs.Platform, containerName string) (container.CreateResponse, error)
+	ImageInspectWithRaw(ctx context.Context, imageID string) (types.ImageInspect, []byte, error)
+	Events(ctx context.Context, options types.EventsOptions) (<-chan events.Message, <-chan error)
+	ContainerExecCreate(ctx context.Context, containerID string, config types.ExecConfig) (types.IDResponse, error)
+	ContainerExecAttach(ctx context.Context, execID string, config types.ExecStartCheck) (types.HijackedResponse, error)
+	ContainerExecResize(ctx context.Context, execID string, options types.ResizeOptions) error
+	NetworkCreate(ctx context.Context, name string, options types.NetworkCreate) (types.NetworkCreateResponse, error)
+	NetworkRemove(ctx context.Context, networkID string) error
+	VolumeCreate(ctx context.Context, options volume.CreateOptions) (volume.Volume, error)
+	VolumeRemove(ctx context.Context, volumeID string, force bool) error
 }
 
 // DockerMetrics represents Docker container metrics
@@ -39,36 +207,133 @@ type DockerMetrics struct {
 
 // ContainerInfo represents container information
 type ContainerInfo struct {
-	ID      string            `json:"id"`
-	Name    string            `json:"name"`
-	Image   string            `json:"image"`
-	Status  string            `json:"status"`
-	State   string            `json:"state"`
-	Created int64             `json:"created"`
-	Ports   []types.Port      `json:"ports"`
-	Labels  map[string]string `json:"labels"`
+	ID             string            `json:"id"`
+	Name           string            `json:"name"`
+	Image          string            `json:"image"`
+	Status         string            `json:"status"`
+	State          string            `json:"state"`
+	Created        int64             `json:"created"`
+	Ports          []types.Port      `json:"ports"`
+	Labels         map[string]string `json:"labels"`
+	ComposeProject string            `json:"compose_project,omitempty"`
+	ComposeService string            `json:"compose_service,omitempty"`
+}
+
+// ComposeProjectInfo groups containers that belong to the same Compose
+// project so the server can treat them as a logical unit rather than a set
+// of unrelated containers.
+type ComposeProjectInfo struct {
+	Project  string                         `json:"project"`
+	Services map[string]*ComposeServiceInfo `json:"services"`
+}
+
+// ComposeServiceInfo tracks the containers backing one Compose service
+// within a project.
+type ComposeServiceInfo struct {
+	Containers    []string `json:"containers"`
+	RunningCount  int      `json:"running_count"`
+	DeclaredCount int      `json:"declared_count,omitempty"`
 }
 
+const (
+	composeProjectLabel = "com.docker.compose.project"
+	composeServiceLabel = "com.docker.compose.service"
+	stackLabel          = "sams.stack"
+	stackServiceLabel   = "sams.service"
+)
+
 // ContainerStats represents container statistics
 type ContainerStats struct {
-	ID           string  `json:"id"`
-	Name         string  `json:"name"`
-	CPUPercent   float64 `json:"cpu_percent"`
-	MemoryUsage  uint64  `json:"memory_usage"`
-	MemoryLimit  uint64  `json:"memory_limit"`
+	ID            string  `json:"id"`
+	Name          string  `json:"name"`
+	Image         string  `json:"image"`
+	State         string  `json:"state"`
+	CPUPercent    float64 `json:"cpu_percent"`
+	MemoryUsage   uint64  `json:"memory_usage"`
+	MemoryLimit   uint64  `json:"memory_limit"`
 	MemoryPercent float64 `json:"memory_percent"`
-	NetworkRx    uint64  `json:"network_rx"`
-	NetworkTx    uint64  `json:"network_tx"`
-	BlockRead    uint64  `json:"block_read"`
-	BlockWrite   uint64  `json:"block_write"`
+	NetworkRx     uint64  `json:"network_rx"`
+	NetworkTx     uint64  `json:"network_tx"`
+	BlockRead     uint64  `json:"block_read"`
+	BlockWrite    uint64  `json:"block_write"`
 }
 
 // DockerAgent represents the Docker monitoring agent
 type DockerAgent struct {
-	config       Config
-	dockerClient *client.Client
+	config       *Config
+	dockerClient DockerClient
 	httpClient   *http.Client
 	running      bool
+	ctx          context.Context
+	cancel       context.CancelFunc
+	streamSem    chan struct{}
+	metricsMu    sync.RWMutex
+	metrics      metricsSnapshot
+	cgroupStats  *cgroupStatsCollector
+	pulls        *pullCoordinator
+
+	wsMu      sync.RWMutex
+	wsConn    *websocket.Conn
+	wsWriteMu *sync.Mutex
+
+	progressMu       sync.Mutex
+	lastProgressSent map[string]time.Time
+
+	commandsMu     sync.Mutex
+	commandCancels map[string]context.CancelFunc
+
+	serverPubKey ed25519.PublicKey
+	agentPrivKey ed25519.PrivateKey
+	commandSkew  time.Duration
+	seenNonces   *nonceCache
+
+	statsMu      sync.Mutex
+	statsSamples map[string][]ContainerStats
+
+	// transport is how sendToServer and the non-HTTP command channel
+	// actually move bytes; see transport.go. Left nil falls back to
+	// plain HTTP+JSON, so tests constructing a DockerAgent directly don't
+	// need to set it.
+	transport Transport
+
+	// idempotency caches executeCommand's response per command ID; see
+	// dispatcher.go. Left nil, every command executes even if repeated.
+	idempotency *commandIdempotencyCache
+}
+
+// setCommandConn records the currently connected command channel so other
+// goroutines (e.g. an in-flight image pull) can push frames over it instead
+// of falling back to HTTP POSTs.
+func (da *DockerAgent) setCommandConn(conn *websocket.Conn, writeMu *sync.Mutex) {
+	da.wsMu.Lock()
+	defer da.wsMu.Unlock()
+	da.wsConn = conn
+	da.wsWriteMu = writeMu
+}
+
+// clearCommandConn forgets the command channel connection once it closes.
+func (da *DockerAgent) clearCommandConn() {
+	da.wsMu.Lock()
+	defer da.wsMu.Unlock()
+	da.wsConn = nil
+	da.wsWriteMu = nil
+}
+
+// commandConn returns the currently connected command channel, or a nil
+// conn if none is connected.
+func (da *DockerAgent) commandConn() (*websocket.Conn, *sync.Mutex) {
+	da.wsMu.RLock()
+	defer da.wsMu.RUnlock()
+	return da.wsConn, da.wsWriteMu
+}
+
+// metricsSnapshot holds the most recently collected data the /metrics
+// endpoint serves, so a Prometheus scrape never triggers a fresh Docker API
+// call of its own.
+type metricsSnapshot struct {
+	containerStats []ContainerStats
+	daemonUp       bool
+	alerts         []map[string]interface{}
 }
 
 // NewDockerAgent creates a new Docker agent
@@ -78,32 +343,89 @@ func NewDockerAgent(configPath string) (*DockerAgent, error) {
 		return nil, fmt.Errorf("failed to load config: %v", err)
 	}
 
-	dockerClient, err := client.NewClientWithOpts(client.FromEnv, client.WithAPIVersionNegotiation())
+	clientOpts := []client.Opt{client.FromEnv, client.WithAPIVersionNegotiation()}
+	if config.DockerSocket != "" {
+		clientOpts = append(clientOpts, client.WithHost("unix://"+config.DockerSocket))
+	}
+
+	dockerClient, err := client.NewClientWithOpts(clientOpts...)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create Docker client: %v", err)
 	}
 
+	tlsConfig, err := buildClientTLSConfig(config.TLSCertFile, config.TLSKeyFile, config.TLSCAFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to configure mTLS: %v", err)
+	}
+
 	httpClient := &http.Client{
 		Timeout: 30 * time.Second,
 	}
+	if tlsConfig != nil {
+		httpClient.Transport = &http.Transport{TLSClientConfig: tlsConfig}
+	}
 
-	return &DockerAgent{
-		config:       config,
-		dockerClient: dockerClient,
-		httpClient:   httpClient,
-		running:      false,
-	}, nil
+	maxStreams := config.MaxConcurrentStreams
+	if maxStreams <= 0 {
+		maxStreams = 10
+	}
+
+	serverPubKey, agentPrivKey, err := loadEnvelopeKeys(config.ServerPublicKeyHex, config.AgentPrivateKeyHex)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load command envelope keys: %v", err)
+	}
+
+	commandSkew := time.Duration(config.CommandSkewSeconds) * time.Second
+	if commandSkew <= 0 {
+		commandSkew = 30 * time.Second
+	}
+
+	agent := &DockerAgent{
+		config:           &config,
+		dockerClient:     dockerClient,
+		httpClient:       httpClient,
+		running:          false,
+		streamSem:        make(chan struct{}, maxStreams),
+		cgroupStats:      newCgroupStatsCollector(),
+		pulls:            newPullCoordinator(),
+		lastProgressSent: make(map[string]time.Time),
+		commandCancels:   make(map[string]context.CancelFunc),
+		serverPubKey:     serverPubKey,
+		agentPrivKey:     agentPrivKey,
+		commandSkew:      commandSkew,
+		seenNonces:       newNonceCache(nonceCacheSize),
+		statsSamples:     make(map[string][]ContainerStats),
+	}
+
+	transport, err := newTransport(agent)
+	if err != nil {
+		return nil, fmt.Errorf("failed to configure transport: %v", err)
+	}
+	agent.transport = transport
+
+	if config.IdempotencyCachePath != "" {
+		idempotency, err := newCommandIdempotencyCache(config.IdempotencyCachePath)
+		if err != nil {
+			return nil, err
+		}
+		agent.idempotency = idempotency
+	}
+
+	return agent, nil
 }
 
 // loadConfig loads configuration from JSON file
 func loadConfig(configPath string) (Config, error) {
 	var config Config
-	
+
 	// Default configuration
 	config = Config{
-		ServerURL:          "http://localhost:8080",
-		CollectionInterval: 30,
-		LogLevel:          "INFO",
+		ServerURL:       "http://localhost:8080",
+		MetricsInterval: 30,
+		LogLevel:        "INFO",
+		MetricsBindAddr: ":9323",
+		StatsBackend:    statsBackendAuto,
+		Transport:       transportHTTP,
 	}
 
 	if configPath != "" {
@@ -133,6 +455,7 @@ func loadConfig(configPath string) (Config, error) {
 func (da *DockerAgent) Start() error {
 	log.Printf("Starting SAMS Docker Agent: %s", da.config.AgentID)
 	da.running = true
+	da.ctx, da.cancel = context.WithCancel(context.Background())
 
 	// Setup signal handling
 	sigChan := make(chan os.Signal, 1)
@@ -143,19 +466,38 @@ func (da *DockerAgent) Start() error {
 		log.Printf("Failed to register agent: %v", err)
 	}
 
+	// Subscribe to the Docker event stream for sub-second alerting, decoupled
+	// from the periodic collection tick below.
+	go da.subscribeToEvents(da.ctx)
+
+	// Maintain the persistent command channel used for remote dispatch,
+	// log-follow and exec streaming.
+	go da.handleRemoteCommands(da.ctx)
+
+	// Serve the last-collected metrics in Prometheus exposition format so
+	// existing Prometheus/VictoriaMetrics stacks can scrape the agent
+	// directly, alongside the push-to-SAMS-server flow.
+	if da.config.MetricsBindAddr != "" {
+		go da.startMetricsServer(da.ctx)
+	}
+
+	// Periodically reconcile running containers against registry image
+	// drift when auto-update is enabled.
+	go da.runAutoUpdateLoop(da.ctx)
+
 	// Start metrics collection
-	ticker := time.NewTicker(time.Duration(da.config.CollectionInterval) * time.Second)
+	ticker := time.NewTicker(time.Duration(da.config.MetricsInterval) * time.Second)
 	defer ticker.Stop()
 
 	for da.running {
 		select {
 		case <-ticker.C:
-			if err := da.collectAndSendMetrics(); err != nil {
+			if err := da.collectAndSendMetrics(da.ctx); err != nil {
 				log.Printf("Error collecting metrics: %v", err)
 			}
 		case <-sigChan:
 			log.Println("Received shutdown signal")
-			da.running = false
+			da.Stop()
 		}
 	}
 
@@ -163,10 +505,149 @@ func (da *DockerAgent) Start() error {
 	return nil
 }
 
+// Stop signals the agent to shut down and cancels any in-flight subscriptions.
+func (da *DockerAgent) Stop() {
+	da.running = false
+	if da.cancel != nil {
+		da.cancel()
+	}
+}
+
+// subscribeToEvents maintains a long-lived subscription to the Docker event
+// stream, normalizing each event into the same alert schema used by
+// createAlert and pushing it to the server immediately. If the daemon
+// restarts or the stream errors out, it reconnects with exponential backoff.
+func (da *DockerAgent) subscribeToEvents(ctx context.Context) {
+	backoff := time.Second
+	const maxBackoff = 30 * time.Second
+
+	for da.running {
+		msgCh, errCh := da.dockerClient.Events(ctx, types.EventsOptions{Filters: da.buildEventFilters()})
+		log.Println("Subscribed to Docker event stream")
+		backoff = time.Second
+
+		disconnected := false
+		for !disconnected {
+			select {
+			case <-ctx.Done():
+				return
+			case msg, ok := <-msgCh:
+				if !ok {
+					disconnected = true
+					break
+				}
+				if alert := da.eventToAlert(msg); alert != nil {
+					if err := da.sendToServer("/api/v1/alerts", alert); err != nil {
+						log.Printf("Failed to send event alert: %v", err)
+					}
+				}
+			case err, ok := <-errCh:
+				if ok && err != nil {
+					log.Printf("Docker event stream error: %v", err)
+				}
+				disconnected = true
+			}
+		}
+
+		if !da.running {
+			return
+		}
+
+		log.Printf("Docker event stream disconnected, reconnecting in %s", backoff)
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+		if backoff > maxBackoff {
+			backoff = maxBackoff
+		}
+	}
+}
+
+// buildEventFilters translates the agent's EventFilterConfig into Docker
+// event filter args so operators can scope the stream to what they care about.
+func (da *DockerAgent) buildEventFilters() filters.Args {
+	filterArgs := filters.NewArgs()
+	filterArgs.Add("type", "container")
+	filterArgs.Add("type", "image")
+	filterArgs.Add("type", "volume")
+	filterArgs.Add("type", "network")
+
+	for _, selector := range da.config.EventFilters.LabelSelectors {
+		filterArgs.Add("label", selector)
+	}
+
+	return filterArgs
+}
+
+// eventToAlert normalizes a raw Docker event into the agent's alert schema,
+// or returns nil when the event shouldn't be forwarded (e.g. a clean exit
+// when OnlyNonZeroExitDie is set).
+func (da *DockerAgent) eventToAlert(msg events.Message) map[string]interface{} {
+	name := msg.Actor.Attributes["name"]
+
+	switch msg.Type {
+	case events.ContainerEventType:
+		switch msg.Action {
+		case "die":
+			exitCode := msg.Actor.Attributes["exitCode"]
+			if da.config.EventFilters.OnlyNonZeroExitDie && exitCode == "0" {
+				return nil
+			}
+			severity := "low"
+			if exitCode != "0" {
+				severity = "high"
+			}
+			return da.createAlert("container_exited", severity,
+				fmt.Sprintf("Container %s exited with code %s", name, exitCode),
+				map[string]interface{}{
+					"container_id":   msg.Actor.ID,
+					"container_name": name,
+					"exit_code":      exitCode,
+					"image":          msg.Actor.Attributes["image"],
+				})
+		case "oom":
+			return da.createAlert("container_oom", "critical",
+				fmt.Sprintf("Container %s was OOM-killed", name),
+				map[string]interface{}{"container_id": msg.Actor.ID, "container_name": name})
+		case "kill":
+			return da.createAlert("container_killed", "high",
+				fmt.Sprintf("Container %s received a kill signal", name),
+				map[string]interface{}{"container_id": msg.Actor.ID, "container_name": name})
+		case "health_status: unhealthy":
+			return da.createAlert("container_unhealthy", "high",
+				fmt.Sprintf("Container %s reported unhealthy", name),
+				map[string]interface{}{"container_id": msg.Actor.ID, "container_name": name})
+		}
+	case events.ImageEventType:
+		if msg.Action == "pull" {
+			return da.createAlert("image_pulled", "low",
+				fmt.Sprintf("Image %s pulled", msg.Actor.ID),
+				map[string]interface{}{"image": msg.Actor.ID})
+		}
+	case events.VolumeEventType:
+		if msg.Action == "create" || msg.Action == "destroy" {
+			return da.createAlert("volume_"+string(msg.Action), "low",
+				fmt.Sprintf("Volume %s %s", msg.Actor.ID, msg.Action),
+				map[string]interface{}{"volume": msg.Actor.ID})
+		}
+	case events.NetworkEventType:
+		if msg.Action == "create" || msg.Action == "destroy" {
+			return da.createAlert("network_"+string(msg.Action), "low",
+				fmt.Sprintf("Network %s %s", msg.Actor.ID, msg.Action),
+				map[string]interface{}{"network": msg.Actor.ID})
+		}
+	}
+
+	return nil
+}
+
 // registerAgent registers the agent with the SAMS server
 func (da *DockerAgent) registerAgent() error {
 	hostname, _ := os.Hostname()
-	
+
 	registrationData := map[string]interface{}{
 		"agentId":      da.config.AgentID,
 		"agentType":    "docker",
@@ -179,8 +660,7 @@ func (da *DockerAgent) registerAgent() error {
 }
 
 // collectAndSendMetrics collects Docker metrics and sends them to the server
-func (da *DockerAgent) collectAndSendMetrics() error {
-	ctx := context.Background()
+func (da *DockerAgent) collectAndSendMetrics(ctx context.Context) error {
 	hostname, _ := os.Hostname()
 
 	metrics := DockerMetrics{
@@ -196,6 +676,7 @@ func (da *DockerAgent) collectAndSendMetrics() error {
 		return fmt.Errorf("failed to collect container info: %v", err)
 	}
 	metrics.Metrics["containers"] = containers
+	metrics.Metrics["compose_projects"] = groupComposeProjects(containers)
 
 	// Collect container statistics
 	stats, err := da.collectContainerStats(ctx)
@@ -215,7 +696,9 @@ func (da *DockerAgent) collectAndSendMetrics() error {
 	alerts, err := da.performHealthChecks(ctx, stats)
 	if err != nil {
 		log.Printf("Failed to perform health checks: %v", err)
-	} else if len(alerts) > 0 {
+	}
+	alerts = append(alerts, da.checkComposeReplicas(groupComposeProjects(containers))...)
+	if len(alerts) > 0 {
 		metrics.Metrics["alerts"] = alerts
 		// Send alerts immediately
 		for _, alert := range alerts {
@@ -225,10 +708,25 @@ func (da *DockerAgent) collectAndSendMetrics() error {
 		}
 	}
 
+	da.updateMetricsSnapshot(stats, da.checkDockerDaemonHealth(ctx) == nil, alerts)
+
 	// Send metrics to server
 	return da.sendToServer("/api/v1/metrics", metrics)
 }
 
+// updateMetricsSnapshot records the latest collection results for the
+// /metrics endpoint to serve, so a scrape doesn't trigger its own Docker API
+// calls.
+func (da *DockerAgent) updateMetricsSnapshot(stats []ContainerStats, daemonUp bool, alerts []map[string]interface{}) {
+	da.metricsMu.Lock()
+	defer da.metricsMu.Unlock()
+	da.metrics = metricsSnapshot{
+		containerStats: stats,
+		daemonUp:       daemonUp,
+		alerts:         alerts,
+	}
+}
+
 // collectContainerInfo collects information about all containers
 func (da *DockerAgent) collectContainerInfo(ctx context.Context) ([]ContainerInfo, error) {
 	containers, err := da.dockerClient.ContainerList(ctx, types.ContainerListOptions{All: true})
@@ -239,14 +737,16 @@ func (da *DockerAgent) collectContainerInfo(ctx context.Context) ([]ContainerInf
 	var containerInfos []ContainerInfo
 	for _, container := range containers {
 		info := ContainerInfo{
-			ID:      container.ID[:12], // Short ID
-			Name:    container.Names[0][1:], // Remove leading slash
-			Image:   container.Image,
-			Status:  container.Status,
-			State:   container.State,
-			Created: container.Created,
-			Ports:   container.Ports,
-			Labels:  container.Labels,
+			ID:             shortID(container.ID),  // Short ID
+			Name:           container.Names[0][1:], // Remove leading slash
+			Image:          container.Image,
+			Status:         container.Status,
+			State:          container.State,
+			Created:        container.Created,
+			Ports:          container.Ports,
+			Labels:         container.Labels,
+			ComposeProject: container.Labels[composeProjectLabel],
+			ComposeService: container.Labels[composeServiceLabel],
 		}
 		containerInfos = append(containerInfos, info)
 	}
@@ -254,81 +754,267 @@ func (da *DockerAgent) collectContainerInfo(ctx context.Context) ([]ContainerInf
 	return containerInfos, nil
 }
 
-// collectContainerStats collects statistics for running containers
+// groupComposeProjects groups containers by their Compose project/service
+// labels so the server sees each project as a logical unit instead of a set
+// of unrelated containers.
+func groupComposeProjects(containers []ContainerInfo) map[string]*ComposeProjectInfo {
+	projects := make(map[string]*ComposeProjectInfo)
+
+	for _, c := range containers {
+		if c.ComposeProject == "" {
+			continue
+		}
+
+		project, ok := projects[c.ComposeProject]
+		if !ok {
+			project = &ComposeProjectInfo{
+				Project:  c.ComposeProject,
+				Services: make(map[string]*ComposeServiceInfo),
+			}
+			projects[c.ComposeProject] = project
+		}
+
+		service, ok := project.Services[c.ComposeService]
+		if !ok {
+			service = &ComposeServiceInfo{}
+			project.Services[c.ComposeService] = service
+		}
+
+		service.Containers = append(service.Containers, c.ID)
+		if c.State == "running" {
+			service.RunningCount++
+		}
+	}
+
+	return projects
+}
+
+// checkComposeReplicas alerts when a Compose service has fewer running
+// replicas than declared in Config.ComposeReplicas.
+func (da *DockerAgent) checkComposeReplicas(projects map[string]*ComposeProjectInfo) []map[string]interface{} {
+	var alerts []map[string]interface{}
+
+	for _, project := range projects {
+		for serviceName, service := range project.Services {
+			declared, ok := da.config.ComposeReplicas[project.Project+"/"+serviceName]
+			if !ok {
+				continue
+			}
+			service.DeclaredCount = declared
+
+			if service.RunningCount < declared {
+				alert := da.createAlert("compose_under_replicated", "high",
+					fmt.Sprintf("Compose service %s/%s has %d/%d replicas running",
+						project.Project, serviceName, service.RunningCount, declared),
+					map[string]interface{}{
+						"project":        project.Project,
+						"service":        serviceName,
+						"running_count":  service.RunningCount,
+						"declared_count": declared,
+					})
+				alerts = append(alerts, alert)
+			}
+		}
+	}
+
+	return alerts
+}
+
+// collectContainerStats collects statistics for running containers, using
+// whichever backend Config.StatsBackend selects. "cgroup" and "auto" read
+// straight from the host's cgroup filesystem to avoid opening a streaming
+// Docker stats connection per container per cycle; "auto" and "cgroup" both
+// fall back to the Docker stats API for a container if its cgroup files
+// can't be read (e.g. the collector isn't available on this platform).
 func (da *DockerAgent) collectContainerStats(ctx context.Context) ([]ContainerStats, error) {
 	containers, err := da.dockerClient.ContainerList(ctx, types.ContainerListOptions{})
 	if err != nil {
 		return nil, err
 	}
 
+	tryCgroup := da.config.StatsBackend != statsBackendAPI && da.cgroupStats.available()
+
 	var containerStats []ContainerStats
 	for _, container := range containers {
-		stats, err := da.dockerClient.ContainerStats(ctx, container.ID, false)
-		if err != nil {
-			log.Printf("Failed to get stats for container %s: %v", container.ID, err)
-			continue
+		var stat ContainerStats
+		var statErr error
+
+		if tryCgroup {
+			stat, statErr = da.collectContainerStatsFromCgroup(ctx, container)
+			if statErr != nil {
+				log.Printf("Falling back to Docker stats API for container %s: %v", shortID(container.ID), statErr)
+				stat, statErr = da.collectContainerStatsFromAPI(ctx, container)
+			}
+		} else {
+			stat, statErr = da.collectContainerStatsFromAPI(ctx, container)
 		}
 
-		var statsData types.StatsJSON
-		if err := json.NewDecoder(stats.Body).Decode(&statsData); err != nil {
-			stats.Body.Close()
+		if statErr != nil {
+			log.Printf("Failed to get stats for container %s: %v", container.ID, statErr)
 			continue
 		}
-		stats.Body.Close()
 
-		// Calculate CPU percentage
-		cpuPercent := calculateCPUPercent(&statsData)
+		containerStats = append(containerStats, stat)
+	}
 
-		// Calculate memory percentage
-		memoryPercent := float64(statsData.MemoryStats.Usage) / float64(statsData.MemoryStats.Limit) * 100
+	return containerStats, nil
+}
 
-		// Calculate network I/O
-		var networkRx, networkTx uint64
-		for _, network := range statsData.Networks {
-			networkRx += network.RxBytes
-			networkTx += network.TxBytes
-		}
+// collectContainerStatsFromAPI retrieves a single container's stats via the
+// Docker stats API. It streams rather than takes a one-shot reading because
+// the daemon always zeroes PreCPUStats on the first frame of a stream,
+// making CPU% uncomputable from a single sample; reading a few frames gives
+// a real previous-to-current delta instead.
+func (da *DockerAgent) collectContainerStatsFromAPI(ctx context.Context, container types.Container) (ContainerStats, error) {
+	stats, err := da.dockerClient.ContainerStats(ctx, container.ID, true)
+	if err != nil {
+		return ContainerStats{}, err
+	}
+	defer stats.Body.Close()
 
-		// Calculate block I/O
-		var blockRead, blockWrite uint64
-		for _, blkio := range statsData.BlkioStats.IoServiceBytesRecursive {
-			if blkio.Op == "Read" {
-				blockRead += blkio.Value
-			} else if blkio.Op == "Write" {
-				blockWrite += blkio.Value
-			}
-		}
+	statsData, err := readLastStatsSample(stats.Body, statsStreamSamples)
+	if err != nil {
+		return ContainerStats{}, err
+	}
+
+	// Calculate CPU percentage
+	cpuPercent := calculateCPUPercent(statsData)
+
+	// Calculate memory usage as the working set (usage minus reclaimable
+	// page cache), matching how `docker stats` reports memory.
+	memoryUsage := workingSetMemory(statsData)
+	memoryPercent := float64(memoryUsage) / float64(statsData.MemoryStats.Limit) * 100
 
-		containerStat := ContainerStats{
-			ID:            container.ID[:12],
-			Name:          container.Names[0][1:],
-			CPUPercent:    cpuPercent,
-			MemoryUsage:   statsData.MemoryStats.Usage,
-			MemoryLimit:   statsData.MemoryStats.Limit,
-			MemoryPercent: memoryPercent,
-			NetworkRx:     networkRx,
-			NetworkTx:     networkTx,
-			BlockRead:     blockRead,
-			BlockWrite:    blockWrite,
+	// Calculate network I/O
+	var networkRx, networkTx uint64
+	for _, network := range statsData.Networks {
+		networkRx += network.RxBytes
+		networkTx += network.TxBytes
+	}
+
+	// Calculate block I/O
+	var blockRead, blockWrite uint64
+	for _, blkio := range statsData.BlkioStats.IoServiceBytesRecursive {
+		if blkio.Op == "Read" {
+			blockRead += blkio.Value
+		} else if blkio.Op == "Write" {
+			blockWrite += blkio.Value
 		}
+	}
+
+	return ContainerStats{
+		ID:            shortID(container.ID),
+		Name:          container.Names[0][1:],
+		Image:         container.Image,
+		State:         container.State,
+		CPUPercent:    cpuPercent,
+		MemoryUsage:   memoryUsage,
+		MemoryLimit:   statsData.MemoryStats.Limit,
+		MemoryPercent: memoryPercent,
+		NetworkRx:     networkRx,
+		NetworkTx:     networkTx,
+		BlockRead:     blockRead,
+		BlockWrite:    blockWrite,
+	}, nil
+}
 
-		containerStats = append(containerStats, containerStat)
+// collectContainerStatsFromCgroup retrieves a single container's stats
+// directly from its cgroup, looking up its cgroup parent and init PID via
+// ContainerInspect first.
+func (da *DockerAgent) collectContainerStatsFromCgroup(ctx context.Context, container types.Container) (ContainerStats, error) {
+	inspect, err := da.dockerClient.ContainerInspect(ctx, container.ID)
+	if err != nil {
+		return ContainerStats{}, err
 	}
 
-	return containerStats, nil
+	var cgroupParent string
+	if inspect.HostConfig != nil {
+		cgroupParent = inspect.HostConfig.CgroupParent
+	}
+
+	cpuPercent, memUsage, memLimit, netRx, netTx, err := da.cgroupStats.collect(container.ID, cgroupParent, inspect.State.Pid)
+	if err != nil {
+		return ContainerStats{}, err
+	}
+
+	memoryPercent := 0.0
+	if memLimit > 0 {
+		memoryPercent = float64(memUsage) / float64(memLimit) * 100
+	}
+
+	return ContainerStats{
+		ID:            shortID(container.ID),
+		Name:          container.Names[0][1:],
+		Image:         container.Image,
+		State:         container.State,
+		CPUPercent:    cpuPercent,
+		MemoryUsage:   memUsage,
+		MemoryLimit:   memLimit,
+		MemoryPercent: memoryPercent,
+		NetworkRx:     netRx,
+		NetworkTx:     netTx,
+	}, nil
+}
+
+// shortID truncates a Docker ID to its conventional 12-character short form,
+// without panicking on the shorter fake IDs test doubles tend to use.
+func shortID(id string) string {
+	if len(id) > 12 {
+		return id[:12]
+	}
+	return id
 }
 
 // calculateCPUPercent calculates CPU usage percentage
 func calculateCPUPercent(stats *types.StatsJSON) float64 {
 	cpuDelta := float64(stats.CPUStats.CPUUsage.TotalUsage - stats.PreCPUStats.CPUUsage.TotalUsage)
 	systemDelta := float64(stats.CPUStats.SystemUsage - stats.PreCPUStats.SystemUsage)
-	
+
 	if systemDelta > 0 && cpuDelta > 0 {
 		return (cpuDelta / systemDelta) * float64(len(stats.CPUStats.CPUUsage.PercpuUsage)) * 100
 	}
 	return 0
 }
 
+// readLastStatsSample decodes up to n JSON frames from a streaming
+// ContainerStats response and returns the last one, so cpuPercent is
+// computed from a real delta rather than the always-zero PreCPUStats a
+// one-shot read returns.
+func readLastStatsSample(body io.Reader, n int) (*types.StatsJSON, error) {
+	decoder := json.NewDecoder(body)
+
+	var last types.StatsJSON
+	var seen int
+	for seen < n {
+		var sample types.StatsJSON
+		if err := decoder.Decode(&sample); err != nil {
+			if err == io.EOF {
+				break
+			}
+			return nil, err
+		}
+		last = sample
+		seen++
+	}
+
+	if seen == 0 {
+		return nil, fmt.Errorf("no stats samples received")
+	}
+	return &last, nil
+}
+
+// workingSetMemory returns a container's resident memory usage with
+// reclaimable page cache subtracted out, matching how `docker stats`
+// reports memory rather than raw cgroup accounting (which counts cache
+// pages as "used").
+func workingSetMemory(stats *types.StatsJSON) uint64 {
+	cache := stats.MemoryStats.Stats["cache"]
+	if cache >= stats.MemoryStats.Usage {
+		return 0
+	}
+	return stats.MemoryStats.Usage - cache
+}
+
 // collectSystemInfo collects Docker system information
 func (da *DockerAgent) collectSystemInfo(ctx context.Context) (map[string]interface{}, error) {
 	info, err := da.dockerClient.Info(ctx)
@@ -365,22 +1051,22 @@ func (da *DockerAgent) collectSystemInfo(ctx context.Context) (map[string]interf
 		"containers_paused":  info.ContainersPaused,
 		"containers_stopped": info.ContainersStopped,
 		"containers_total":   info.Containers,
-		"images":            info.Images,
-		"server_version":    info.ServerVersion,
-		"kernel_version":    info.KernelVersion,
-		"operating_system":  info.OperatingSystem,
-		"architecture":      info.Architecture,
-		"ncpu":             info.NCPU,
-		"mem_total":        info.MemTotal,
-		"docker_root_dir":  info.DockerRootDir,
-		"storage_driver":   info.Driver,
-		"logging_driver":   info.LoggingDriver,
-		"cgroup_driver":    info.CgroupDriver,
-		"swarm_mode":       info.Swarm.LocalNodeState != "inactive",
-		"version_info":     version,
-		"image_details":    images,
-		"volume_details":   volumes,
-		"network_details":  networks,
+		"images":             info.Images,
+		"server_version":     info.ServerVersion,
+		"kernel_version":     info.KernelVersion,
+		"operating_system":   info.OperatingSystem,
+		"architecture":       info.Architecture,
+		"ncpu":               info.NCPU,
+		"mem_total":          info.MemTotal,
+		"docker_root_dir":    info.DockerRootDir,
+		"storage_driver":     info.Driver,
+		"logging_driver":     info.LoggingDriver,
+		"cgroup_driver":      info.CgroupDriver,
+		"swarm_mode":         info.Swarm.LocalNodeState != "inactive",
+		"version_info":       version,
+		"image_details":      images,
+		"volume_details":     volumes,
+		"network_details":    networks,
 	}
 
 	return systemInfo, nil
@@ -403,13 +1089,13 @@ func (da *DockerAgent) collectImageInfo(ctx context.Context) ([]map[string]inter
 		}
 
 		imageInfo := map[string]interface{}{
-			"id":         image.ID[:12],
-			"repo_tags":  repoTags,
-			"created":    image.Created,
-			"size":       image.Size,
+			"id":           shortID(image.ID),
+			"repo_tags":    repoTags,
+			"created":      image.Created,
+			"size":         image.Size,
 			"virtual_size": image.VirtualSize,
-			"shared_size": image.SharedSize,
-			"containers": image.Containers,
+			"shared_size":  image.SharedSize,
+			"containers":   image.Containers,
 		}
 		imageInfos = append(imageInfos, imageInfo)
 	}
@@ -419,7 +1105,7 @@ func (da *DockerAgent) collectImageInfo(ctx context.Context) ([]map[string]inter
 
 // collectVolumeInfo collects Docker volume information
 func (da *DockerAgent) collectVolumeInfo(ctx context.Context) ([]map[string]interface{}, error) {
-	volumes, err := da.dockerClient.VolumeList(ctx, types.VolumeListOptions{})
+	volumes, err := da.dockerClient.VolumeList(ctx, volume.ListOptions{})
 	if err != nil {
 		return nil, err
 	}
@@ -451,7 +1137,7 @@ func (da *DockerAgent) collectNetworkInfo(ctx context.Context) ([]map[string]int
 	var networkInfos []map[string]interface{}
 	for _, network := range networks {
 		networkInfo := map[string]interface{}{
-			"id":         network.ID[:12],
+			"id":         shortID(network.ID),
 			"name":       network.Name,
 			"driver":     network.Driver,
 			"scope":      network.Scope,
@@ -472,15 +1158,20 @@ func (da *DockerAgent) performHealthChecks(ctx context.Context, containerStats [
 
 	// Check container resource usage
 	for _, stats := range containerStats {
-		// High CPU usage alert
-		if stats.CPUPercent > 80 {
+		// High CPU usage alert, requiring a sustained breach across the
+		// rolling sample window rather than a single instantaneous spike,
+		// so a momentary burst doesn't flap an alert on and off.
+		samples := da.recordStatsSample(stats)
+		if breaches := countCPUBreaches(samples); breaches >= sustainedAlertSamples {
 			alert := da.createAlert("high_cpu_usage", "critical",
-				fmt.Sprintf("Container %s has high CPU usage: %.2f%%", stats.Name, stats.CPUPercent),
+				fmt.Sprintf("Container %s has sustained high CPU usage: %.2f%%", stats.Name, stats.CPUPercent),
 				map[string]interface{}{
-					"container_id": stats.ID,
+					"container_id":   stats.ID,
 					"container_name": stats.Name,
-					"cpu_percent": stats.CPUPercent,
-					"threshold": 80,
+					"cpu_percent":    stats.CPUPercent,
+					"threshold":      highCPUThreshold,
+					"breach_samples": breaches,
+					"window_size":    len(samples),
 				})
 			alerts = append(alerts, alert)
 		}
@@ -490,12 +1181,12 @@ func (da *DockerAgent) performHealthChecks(ctx context.Context, containerStats [
 			alert := da.createAlert("high_memory_usage", "high",
 				fmt.Sprintf("Container %s has high memory usage: %.2f%%", stats.Name, stats.MemoryPercent),
 				map[string]interface{}{
-					"container_id": stats.ID,
+					"container_id":   stats.ID,
 					"container_name": stats.Name,
 					"memory_percent": stats.MemoryPercent,
-					"memory_usage": stats.MemoryUsage,
-					"memory_limit": stats.MemoryLimit,
-					"threshold": 85,
+					"memory_usage":   stats.MemoryUsage,
+					"memory_limit":   stats.MemoryLimit,
+					"threshold":      85,
 				})
 			alerts = append(alerts, alert)
 		}
@@ -533,7 +1224,7 @@ func (da *DockerAgent) performHealthChecks(ctx context.Context, containerStats [
 			fmt.Sprintf("Docker disk usage is high: %.2f%%", diskUsage),
 			map[string]interface{}{
 				"disk_usage_percent": diskUsage,
-				"threshold": 90,
+				"threshold":          90,
 			})
 		alerts = append(alerts, alert)
 	}
@@ -541,6 +1232,37 @@ func (da *DockerAgent) performHealthChecks(ctx context.Context, containerStats [
 	return alerts, nil
 }
 
+// recordStatsSample appends stats to its container's rolling sample window,
+// trimming to the most recent statsHistoryWindow entries, and returns the
+// updated window.
+func (da *DockerAgent) recordStatsSample(stats ContainerStats) []ContainerStats {
+	da.statsMu.Lock()
+	defer da.statsMu.Unlock()
+
+	if da.statsSamples == nil {
+		da.statsSamples = make(map[string][]ContainerStats)
+	}
+
+	samples := append(da.statsSamples[stats.ID], stats)
+	if len(samples) > statsHistoryWindow {
+		samples = samples[len(samples)-statsHistoryWindow:]
+	}
+	da.statsSamples[stats.ID] = samples
+	return samples
+}
+
+// countCPUBreaches returns how many samples in the window exceed
+// highCPUThreshold.
+func countCPUBreaches(samples []ContainerStats) int {
+	count := 0
+	for _, s := range samples {
+		if s.CPUPercent > highCPUThreshold {
+			count++
+		}
+	}
+	return count
+}
+
 // createAlert creates a standardized alert structure
 func (da *DockerAgent) createAlert(alertType, severity, message string, metadata map[string]interface{}) map[string]interface{} {
 	hostname, _ := os.Hostname()
@@ -577,10 +1299,13 @@ func (da *DockerAgent) checkStoppedContainers(ctx context.Context) ([]map[string
 			}
 
 			// If container has restart policy "always" or "unless-stopped", it should be running
-			restartPolicy := inspect.HostConfig.RestartPolicy.Name
+			var restartPolicy string
+			if inspect.HostConfig != nil {
+				restartPolicy = inspect.HostConfig.RestartPolicy.Name
+			}
 			if restartPolicy == "always" || restartPolicy == "unless-stopped" {
 				containerInfo := map[string]interface{}{
-					"id":             container.ID[:12],
+					"id":             shortID(container.ID),
 					"name":           container.Names[0][1:],
 					"image":          container.Image,
 					"state":          container.State,
@@ -651,127 +1376,670 @@ func (da *DockerAgent) checkDockerDiskUsage(ctx context.Context) (float64, error
 	return usagePercent, nil
 }
 
-// handleRemoteCommands handles remote commands from the SAMS server
-func (da *DockerAgent) handleRemoteCommands() {
-	// This would typically be implemented with WebSocket or polling
-	// For now, we'll implement a simple HTTP endpoint check
-	ticker := time.NewTicker(10 * time.Second)
-	defer ticker.Stop()
+// startMetricsServer serves the agent's last-collected data in Prometheus
+// text exposition format at /metrics on Config.MetricsBindAddr, so existing
+// Prometheus/VictoriaMetrics/Grafana stacks can scrape the same data the
+// SAMS server sees. It shuts down when ctx is cancelled.
+func (da *DockerAgent) startMetricsServer(ctx context.Context) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/metrics", da.handleMetrics)
+	mux.HandleFunc("/ws/logs/", da.handleLogsWebSocket)
+	mux.HandleFunc("/ws/pull", da.handlePullWebSocket)
 
-	for da.running {
-		select {
-		case <-ticker.C:
-			if err := da.checkForCommands(); err != nil {
-				log.Printf("Error checking for commands: %v", err)
-			}
-		}
+	server := &http.Server{
+		Addr:    da.config.MetricsBindAddr,
+		Handler: mux,
+	}
+
+	go func() {
+		<-ctx.Done()
+		server.Close()
+	}()
+
+	log.Printf("Serving Prometheus metrics on %s/metrics", da.config.MetricsBindAddr)
+	if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+		log.Printf("Metrics server error: %v", err)
 	}
 }
 
-// checkForCommands checks for pending commands from the server
-func (da *DockerAgent) checkForCommands() error {
-	url := da.config.ServerURL + "/api/v1/agents/" + da.config.AgentID + "/commands"
-	req, err := http.NewRequest("GET", url, nil)
-	if err != nil {
-		return err
+// handleMetrics renders the agent's cached metrics snapshot in Prometheus
+// text exposition format.
+func (da *DockerAgent) handleMetrics(w http.ResponseWriter, r *http.Request) {
+	da.metricsMu.RLock()
+	snapshot := da.metrics
+	da.metricsMu.RUnlock()
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	io.WriteString(w, renderPrometheusMetrics(snapshot))
+}
+
+// renderPrometheusMetrics formats a metricsSnapshot as Prometheus text
+// exposition format. Kept separate from handleMetrics so the format can be
+// tested without an HTTP round trip.
+func renderPrometheusMetrics(snapshot metricsSnapshot) string {
+	var b strings.Builder
+
+	b.WriteString("# HELP docker_container_cpu_percent Container CPU usage percent.\n")
+	b.WriteString("# TYPE docker_container_cpu_percent gauge\n")
+	for _, s := range snapshot.containerStats {
+		fmt.Fprintf(&b, "docker_container_cpu_percent{name=%q,id=%q,image=%q} %f\n", s.Name, s.ID, s.Image, s.CPUPercent)
 	}
 
-	if da.config.APIKey != "" {
-		req.Header.Set("Authorization", "Bearer "+da.config.APIKey)
+	b.WriteString("# HELP docker_container_memory_usage_bytes Container memory usage in bytes.\n")
+	b.WriteString("# TYPE docker_container_memory_usage_bytes gauge\n")
+	for _, s := range snapshot.containerStats {
+		fmt.Fprintf(&b, "docker_container_memory_usage_bytes{name=%q,id=%q,image=%q} %d\n", s.Name, s.ID, s.Image, s.MemoryUsage)
 	}
 
-	resp, err := da.httpClient.Do(req)
-	if err != nil {
-		return err
+	b.WriteString("# HELP docker_container_network_rx_bytes_total Cumulative bytes received by the container's network interfaces.\n")
+	b.WriteString("# TYPE docker_container_network_rx_bytes_total counter\n")
+	for _, s := range snapshot.containerStats {
+		fmt.Fprintf(&b, "docker_container_network_rx_bytes_total{name=%q,id=%q,image=%q} %d\n", s.Name, s.ID, s.Image, s.NetworkRx)
 	}
-	defer resp.Body.Close()
 
-	if resp.StatusCode != http.StatusOK {
-		return nil // No commands or server error
+	b.WriteString("# HELP docker_container_state Whether the container is in the given state (1) or not (0).\n")
+	b.WriteString("# TYPE docker_container_state gauge\n")
+	for _, s := range snapshot.containerStats {
+		fmt.Fprintf(&b, "docker_container_state{name=%q,id=%q,state=%q} 1\n", s.Name, s.ID, s.State)
 	}
 
-	var commands []map[string]interface{}
-	if err := json.NewDecoder(resp.Body).Decode(&commands); err != nil {
-		return err
+	b.WriteString("# HELP docker_daemon_up Whether the last Docker daemon health check succeeded.\n")
+	b.WriteString("# TYPE docker_daemon_up gauge\n")
+	if snapshot.daemonUp {
+		b.WriteString("docker_daemon_up 1\n")
+	} else {
+		b.WriteString("docker_daemon_up 0\n")
 	}
 
-	for _, command := range commands {
-		if err := da.executeCommand(command); err != nil {
-			log.Printf("Failed to execute command %v: %v", command, err)
-		}
+	b.WriteString("# HELP docker_alerts_active Alerts raised during the last collection cycle.\n")
+	b.WriteString("# TYPE docker_alerts_active gauge\n")
+	for _, alert := range snapshot.alerts {
+		fmt.Fprintf(&b, "docker_alerts_active{severity=%q,type=%q} 1\n", alert["severity"], alert["alert_type"])
 	}
 
-	return nil
+	return b.String()
 }
 
-// executeCommand executes a remote command
-func (da *DockerAgent) executeCommand(command map[string]interface{}) error {
-	ctx := context.Background()
-	commandType, ok := command["type"].(string)
-	if !ok {
-		return fmt.Errorf("invalid command type")
-	}
+// commandWSPingInterval is how often the agent pings the command channel to
+// keep it alive and detect a dead connection faster than TCP would.
+const commandWSPingInterval = 15 * time.Second
+
+// handleRemoteCommands maintains the agent's connection for inbound command
+// dispatch. Under the "http" transport (the default) this is a persistent
+// WebSocket carrying both command dispatch and streaming responses
+// (log-follow, exec); under "grpc" or "nats" it's da.transport.Subscribe,
+// which only carries simple request/response commands for now — see
+// runCommandsFromTransport. If the connection drops, it reconnects with the
+// same exponential backoff used by subscribeToEvents.
+func (da *DockerAgent) handleRemoteCommands(ctx context.Context) {
+	backoff := time.Second
+	const maxBackoff = 30 * time.Second
 
-	commandID, _ := command["id"].(string)
-	log.Printf("Executing command: %s (ID: %s)", commandType, commandID)
+	for da.running {
+		var err error
+		if da.config.Transport == "" || da.config.Transport == transportHTTP {
+			err = da.runCommandChannel(ctx)
+		} else {
+			err = da.runCommandsFromTransport(ctx)
+		}
+		if err != nil {
+			log.Printf("Command channel error: %v", err)
+		}
 
-	var result map[string]interface{}
-	var err error
+		if !da.running {
+			return
+		}
 
-	switch commandType {
-	case "container_start":
-		result, err = da.startContainer(ctx, command)
-	case "container_stop":
-		result, err = da.stopContainer(ctx, command)
-	case "container_restart":
-		result, err = da.restartContainer(ctx, command)
-	case "container_remove":
-		result, err = da.removeContainer(ctx, command)
-	case "image_pull":
-		result, err = da.pullImage(ctx, command)
-	case "image_remove":
-		result, err = da.removeImage(ctx, command)
-	case "container_logs":
-		result, err = da.getContainerLogs(ctx, command)
-	case "system_prune":
-		result, err = da.systemPrune(ctx, command)
-	default:
-		err = fmt.Errorf("unknown command type: %s", commandType)
+		log.Printf("Command channel disconnected, reconnecting in %s", backoff)
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+		if backoff > maxBackoff {
+			backoff = maxBackoff
+		}
 	}
+}
 
-	// Send command result back to server
-	response := map[string]interface{}{
-		"command_id": commandID,
-		"agent_id":   da.config.AgentID,
-		"status":     "completed",
-		"result":     result,
-		"timestamp":  time.Now().UTC().Format(time.RFC3339),
+// runCommandsFromTransport subscribes to inbound commands over da.transport
+// and executes them synchronously via executeCommand. Unlike
+// runCommandChannel, there's no shared connection to stream frames back
+// over, so the streaming command types (container_logs_follow,
+// container_exec) aren't supported under the "grpc" and "nats" transports
+// yet; they're rejected with an error result instead of silently dropped.
+func (da *DockerAgent) runCommandsFromTransport(ctx context.Context) error {
+	commands, err := da.transport.Subscribe(ctx, "/api/v1/agents/"+da.config.AgentID+"/commands")
+	if err != nil {
+		return fmt.Errorf("failed to subscribe for commands: %v", err)
 	}
 
-	if err != nil {
-		response["status"] = "failed"
-		response["error"] = err.Error()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case command, ok := <-commands:
+			if !ok {
+				return fmt.Errorf("command subscription closed")
+			}
+			go da.executeTransportCommand(ctx, command)
+		}
 	}
+}
 
-	return da.sendToServer("/api/v1/agents/command-results", response)
+// executeTransportCommand runs a command received over da.transport,
+// refusing the streaming types that require a dedicated WebSocket.
+func (da *DockerAgent) executeTransportCommand(ctx context.Context, command map[string]interface{}) {
+	commandType, _ := command["type"].(string)
+	if commandType == "container_logs_follow" || commandType == "container_exec" {
+		log.Printf("Command type %q requires the http transport's command channel; rejecting", commandType)
+		commandID, _ := command["id"].(string)
+		response := map[string]interface{}{
+			"command_id": commandID,
+			"agent_id":   da.config.AgentID,
+			"status":     "failed",
+			"error":      fmt.Sprintf("command type %s requires the http transport's command channel", commandType),
+			"timestamp":  time.Now().UTC().Format(time.RFC3339),
+		}
+		if err := da.sendToServer("/api/v1/agents/command-results", response); err != nil {
+			log.Printf("Failed to send rejection for command %s: %v", commandID, err)
+		}
+		return
+	}
+	if err := da.executeCommand(ctx, command); err != nil {
+		log.Printf("Failed to execute command %v: %v", command, err)
+	}
 }
 
-// startContainer starts a Docker container
-func (da *DockerAgent) startContainer(ctx context.Context, command map[string]interface{}) (map[string]interface{}, error) {
-	containerID, ok := command["container_id"].(string)
-	if !ok {
-		return nil, fmt.Errorf("container_id is required")
+// runCommandChannel dials the command WebSocket, dispatches inbound commands
+// until the connection closes or errors, and sends a heartbeat ping on
+// commandWSPingInterval so a half-open connection is noticed quickly.
+func (da *DockerAgent) runCommandChannel(ctx context.Context) error {
+	wsURL := strings.Replace(da.config.ServerURL, "http", "ws", 1) + "/api/v1/agents/" + da.config.AgentID + "/ws"
+
+	header := http.Header{}
+	if da.config.APIKey != "" {
+		header.Set("Authorization", "Bearer "+da.config.APIKey)
 	}
 
-	if err := da.dockerClient.ContainerStart(ctx, containerID, types.ContainerStartOptions{}); err != nil {
-		return nil, err
+	conn, _, err := websocket.DefaultDialer.DialContext(ctx, wsURL, header)
+	if err != nil {
+		return fmt.Errorf("failed to dial command channel: %v", err)
 	}
+	defer conn.Close()
 
-	return map[string]interface{}{
-		"container_id": containerID,
-		"action":       "started",
-	}, nil
-}
+	log.Println("Connected to command channel")
+
+	var writeMu sync.Mutex
+	da.setCommandConn(conn, &writeMu)
+	defer da.clearCommandConn()
+
+	pingTicker := time.NewTicker(commandWSPingInterval)
+	defer pingTicker.Stop()
+
+	msgCh := make(chan map[string]interface{})
+	errCh := make(chan error, 1)
+
+	go func() {
+		for {
+			var raw map[string]interface{}
+			if err := conn.ReadJSON(&raw); err != nil {
+				errCh <- err
+				return
+			}
+			command, err := da.verifyInboundCommand(raw)
+			if err != nil {
+				log.Printf("Rejected command envelope: %v", err)
+				continue
+			}
+			msgCh <- command
+		}
+	}()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case err := <-errCh:
+			return err
+		case command := <-msgCh:
+			da.dispatchCommand(ctx, command, conn, &writeMu)
+		case <-pingTicker.C:
+			writeMu.Lock()
+			err := conn.WriteMessage(websocket.PingMessage, nil)
+			writeMu.Unlock()
+			if err != nil {
+				return fmt.Errorf("failed to send heartbeat: %v", err)
+			}
+		}
+	}
+}
+
+// dispatchCommand routes an inbound command to the synchronous executeCommand
+// path it always used, except for the streaming command types which push
+// frames back over the command channel itself instead of waiting for a
+// result to POST.
+func (da *DockerAgent) dispatchCommand(ctx context.Context, command map[string]interface{}, conn *websocket.Conn, writeMu *sync.Mutex) {
+	commandType, _ := command["type"].(string)
+
+	switch commandType {
+	case "container_logs_follow":
+		go da.streamContainerLogs(ctx, command, conn, writeMu)
+	case "container_exec":
+		go da.streamContainerExec(ctx, command, conn, writeMu)
+	default:
+		go func() {
+			if err := da.executeCommand(ctx, command); err != nil {
+				log.Printf("Failed to execute command %v: %v", command, err)
+			}
+		}()
+	}
+}
+
+// acquireStream reserves a slot against Config.MaxConcurrentStreams, so a
+// runaway log-follow or exec session can't exhaust file descriptors. The
+// returned release func must be called once the stream ends.
+func (da *DockerAgent) acquireStream(ctx context.Context) (func(), error) {
+	select {
+	case da.streamSem <- struct{}{}:
+		return func() { <-da.streamSem }, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// writeStreamFrame sends a JSON frame over the shared command channel
+// connection, guarding against concurrent writes from other streams or the
+// heartbeat ticker.
+func writeStreamFrame(conn *websocket.Conn, writeMu *sync.Mutex, frame map[string]interface{}) error {
+	writeMu.Lock()
+	defer writeMu.Unlock()
+	return conn.WriteJSON(frame)
+}
+
+// streamContainerLogs follows a container's logs and pushes demultiplexed
+// stdout/stderr frames over the command channel as they arrive. The one-shot
+// getContainerLogs handler remains for pull-style requests that just want a
+// snapshot of recent output.
+func (da *DockerAgent) streamContainerLogs(ctx context.Context, command map[string]interface{}, conn *websocket.Conn, writeMu *sync.Mutex) {
+	streamID, _ := command["stream_id"].(string)
+	containerID, ok := command["container_id"].(string)
+	if !ok {
+		writeStreamFrame(conn, writeMu, map[string]interface{}{"stream_id": streamID, "error": "container_id is required", "done": true})
+		return
+	}
+
+	release, err := da.acquireStream(ctx)
+	if err != nil {
+		writeStreamFrame(conn, writeMu, map[string]interface{}{"stream_id": streamID, "error": err.Error(), "done": true})
+		return
+	}
+	defer release()
+
+	tail := "all"
+	if t, ok := command["tail"].(string); ok && t != "" {
+		tail = t
+	}
+
+	reader, err := da.dockerClient.ContainerLogs(ctx, containerID, types.ContainerLogsOptions{
+		ShowStdout: true,
+		ShowStderr: true,
+		Follow:     true,
+		Tail:       tail,
+		Since:      stringField(command, "since"),
+		Timestamps: true,
+	})
+	if err != nil {
+		writeStreamFrame(conn, writeMu, map[string]interface{}{"stream_id": streamID, "error": err.Error(), "done": true})
+		return
+	}
+	defer reader.Close()
+
+	stdoutR, stdoutW := io.Pipe()
+	stderrR, stderrW := io.Pipe()
+	go func() {
+		_, err := stdcopy.StdCopy(stdoutW, stderrW, reader)
+		stdoutW.CloseWithError(err)
+		stderrW.CloseWithError(err)
+	}()
+
+	var seq int64
+	forward := func(streamName string, r io.Reader) {
+		buf := make([]byte, 4096)
+		for {
+			n, err := r.Read(buf)
+			if n > 0 {
+				frameSeq := atomic.AddInt64(&seq, 1)
+				writeStreamFrame(conn, writeMu, map[string]interface{}{
+					"stream_id": streamID,
+					"seq":       frameSeq,
+					"stream":    streamName,
+					"data":      string(buf[:n]),
+				})
+			}
+			if err != nil {
+				return
+			}
+		}
+	}
+
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() { defer wg.Done(); forward("stdout", stdoutR) }()
+	go func() { defer wg.Done(); forward("stderr", stderrR) }()
+
+	done := make(chan struct{})
+	go func() { wg.Wait(); close(done) }()
+
+	select {
+	case <-ctx.Done():
+	case <-done:
+	}
+	writeStreamFrame(conn, writeMu, map[string]interface{}{"stream_id": streamID, "done": true})
+}
+
+// streamContainerExec runs an interactive command inside a container,
+// piping stdin frames received on the command channel to the exec session
+// and forwarding its output back as frames, with support for terminal
+// resize messages when the session is a TTY.
+func (da *DockerAgent) streamContainerExec(ctx context.Context, command map[string]interface{}, conn *websocket.Conn, writeMu *sync.Mutex) {
+	streamID, _ := command["stream_id"].(string)
+	containerID, ok := command["container_id"].(string)
+	if !ok {
+		writeStreamFrame(conn, writeMu, map[string]interface{}{"stream_id": streamID, "error": "container_id is required", "done": true})
+		return
+	}
+
+	var cmd []string
+	if rawCmd, ok := command["cmd"].([]interface{}); ok {
+		for _, c := range rawCmd {
+			if s, ok := c.(string); ok {
+				cmd = append(cmd, s)
+			}
+		}
+	}
+	if len(cmd) == 0 {
+		writeStreamFrame(conn, writeMu, map[string]interface{}{"stream_id": streamID, "error": "cmd is required", "done": true})
+		return
+	}
+	tty, _ := command["tty"].(bool)
+
+	release, err := da.acquireStream(ctx)
+	if err != nil {
+		writeStreamFrame(conn, writeMu, map[string]interface{}{"stream_id": streamID, "error": err.Error(), "done": true})
+		return
+	}
+	defer release()
+
+	execResp, err := da.dockerClient.ContainerExecCreate(ctx, containerID, types.ExecConfig{
+		Cmd:          cmd,
+		Tty:          tty,
+		AttachStdin:  true,
+		AttachStdout: true,
+		AttachStderr: true,
+	})
+	if err != nil {
+		writeStreamFrame(conn, writeMu, map[string]interface{}{"stream_id": streamID, "error": err.Error(), "done": true})
+		return
+	}
+
+	hijacked, err := da.dockerClient.ContainerExecAttach(ctx, execResp.ID, types.ExecStartCheck{Tty: tty})
+	if err != nil {
+		writeStreamFrame(conn, writeMu, map[string]interface{}{"stream_id": streamID, "error": err.Error(), "done": true})
+		return
+	}
+	defer hijacked.Close()
+
+	outputDone := make(chan struct{})
+	go func() {
+		defer close(outputDone)
+		buf := make([]byte, 4096)
+		for {
+			n, err := hijacked.Reader.Read(buf)
+			if n > 0 {
+				writeStreamFrame(conn, writeMu, map[string]interface{}{
+					"stream_id": streamID,
+					"stream":    "stdout",
+					"data":      string(buf[:n]),
+				})
+			}
+			if err != nil {
+				return
+			}
+		}
+	}()
+
+	if stdin, ok := command["stdin"].(string); ok && stdin != "" {
+		io.WriteString(hijacked.Conn, stdin)
+	}
+	if width, height, ok := resizeFields(command); ok {
+		da.dockerClient.ContainerExecResize(ctx, execResp.ID, types.ResizeOptions{Width: width, Height: height})
+	}
+
+	select {
+	case <-ctx.Done():
+	case <-outputDone:
+	}
+	writeStreamFrame(conn, writeMu, map[string]interface{}{"stream_id": streamID, "done": true})
+}
+
+// stringField reads an optional string field from a command payload,
+// returning "" when the field is absent or not a string.
+func stringField(command map[string]interface{}, key string) string {
+	if v, ok := command[key].(string); ok {
+		return v
+	}
+	return ""
+}
+
+// resizeFields extracts an optional width/height pair (e.g. from an initial
+// TTY size or a resize message) from a command payload.
+func resizeFields(command map[string]interface{}) (width, height uint, ok bool) {
+	w, wok := command["width"].(float64)
+	h, hok := command["height"].(float64)
+	if !wok || !hok {
+		return 0, 0, false
+	}
+	return uint(w), uint(h), true
+}
+
+// executeCommand executes a remote command
+func (da *DockerAgent) executeCommand(parentCtx context.Context, command map[string]interface{}) error {
+	commandType, ok := command["type"].(string)
+	if !ok {
+		return fmt.Errorf("invalid command type")
+	}
+
+	commandID, _ := command["id"].(string)
+	log.Printf("Executing command: %s (ID: %s)", commandType, commandID)
+
+	if cached, hit, err := da.idempotency.get(commandID); err != nil {
+		log.Printf("Failed to read idempotency cache for %s: %v", commandID, err)
+	} else if hit {
+		log.Printf("Command %s already executed, returning cached result", commandID)
+		return da.sendToServer("/api/v1/agents/command-results", cached)
+	}
+
+	started := time.Now().UTC()
+	actor, _ := command["actor"].(string)
+
+	ctx := parentCtx
+	requestID, _ := command["request_id"].(string)
+	if requestID != "" && commandType != "cancel_command" {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithCancel(parentCtx)
+		da.trackCommand(requestID, cancel)
+		defer da.untrackCommand(requestID)
+	}
+
+	var result map[string]interface{}
+	var err error
+
+	if verr := validateCommand(commandType, command); verr != nil {
+		err = fmt.Errorf("rejected malformed command: %v", verr)
+	} else {
+		switch commandType {
+		case "container_start":
+			result, err = da.startContainer(ctx, command)
+		case "container_stop":
+			result, err = da.stopContainer(ctx, command)
+		case "container_restart":
+			result, err = da.restartContainer(ctx, command)
+		case "container_remove":
+			result, err = da.removeContainer(ctx, command)
+		case "image_pull":
+			result, err = da.pullImage(ctx, command)
+		case "pull_cancel":
+			result, err = da.cancelPull(ctx, command)
+		case "cancel_command":
+			result, err = da.cancelCommand(ctx, command)
+		case "container_deploy":
+			result, err = da.deployContainerCommand(ctx, command)
+		case "image_remove":
+			result, err = da.removeImage(ctx, command)
+		case "container_logs":
+			result, err = da.getContainerLogs(ctx, command)
+		case "system_prune":
+			result, err = da.systemPrune(ctx, command)
+		case "containers_prune":
+			result, err = da.containersPrune(ctx, command)
+		case "images_prune":
+			result, err = da.imagesPrune(ctx, command)
+		case "volumes_prune":
+			result, err = da.volumesPrune(ctx, command)
+		case "networks_prune":
+			result, err = da.networksPrune(ctx, command)
+		case "deploy_stack":
+			result, err = da.deployStack(ctx, command)
+		case "stack_down":
+			result, err = da.stackDown(ctx, command)
+		case "stack_ps":
+			result, err = da.stackPs(ctx, command)
+		case "stack_logs":
+			result, err = da.stackLogs(ctx, command)
+		case "compose_up":
+			result, err = da.composeUp(ctx, command)
+		case "compose_down":
+			result, err = da.composeDown(ctx, command)
+		case "compose_restart":
+			result, err = da.composeRestart(ctx, command)
+		case "compose_pull":
+			result, err = da.composePull(ctx, command)
+		case "compose_ps":
+			result, err = da.composePs(ctx, command)
+		default:
+			err = fmt.Errorf("unknown command type: %s", commandType)
+		}
+	}
+
+	// Send command result back to server
+	response := map[string]interface{}{
+		"command_id": commandID,
+		"agent_id":   da.config.AgentID,
+		"status":     "completed",
+		"result":     result,
+		"timestamp":  time.Now().UTC().Format(time.RFC3339),
+	}
+
+	if err != nil {
+		response["status"] = "failed"
+		response["error"] = err.Error()
+	}
+
+	if putErr := da.idempotency.put(commandID, response); putErr != nil {
+		log.Printf("Failed to cache result for %s: %v", commandID, putErr)
+	}
+
+	da.recordAudit(auditEntry{
+		CommandID:  commandID,
+		Type:       commandType,
+		Actor:      actor,
+		StartedAt:  started,
+		FinishedAt: time.Now().UTC(),
+		Result:     result,
+		Error:      errString(err),
+	})
+
+	return da.sendToServer("/api/v1/agents/command-results", response)
+}
+
+// errString returns err's message, or "" if err is nil, for embedding in a
+// JSON-serializable struct field.
+func errString(err error) string {
+	if err == nil {
+		return ""
+	}
+	return err.Error()
+}
+
+// trackCommand registers the cancel func for a command running under
+// request_id, so cancelCommand can later abort it mid-flight.
+func (da *DockerAgent) trackCommand(requestID string, cancel context.CancelFunc) {
+	da.commandsMu.Lock()
+	da.commandCancels[requestID] = cancel
+	da.commandsMu.Unlock()
+}
+
+// untrackCommand removes a finished command's cancel func, once it no
+// longer needs to be cancellable.
+func (da *DockerAgent) untrackCommand(requestID string) {
+	da.commandsMu.Lock()
+	delete(da.commandCancels, requestID)
+	da.commandsMu.Unlock()
+}
+
+// cancelInFlightCommand cancels the context of the still-running command
+// tracked under requestID, reporting whether one was found.
+func (da *DockerAgent) cancelInFlightCommand(requestID string) bool {
+	da.commandsMu.Lock()
+	cancel, ok := da.commandCancels[requestID]
+	da.commandsMu.Unlock()
+
+	if ok {
+		cancel()
+	}
+	return ok
+}
+
+// cancelCommand aborts an in-flight command by its request_id. It
+// complements pull_cancel (which targets an in-flight pull by image name)
+// with a general mechanism covering any long-running command executeCommand
+// runs, e.g. a huge getContainerLogs fetch or a slow systemPrune.
+func (da *DockerAgent) cancelCommand(ctx context.Context, command map[string]interface{}) (map[string]interface{}, error) {
+	requestID, ok := command["request_id"].(string)
+	if !ok || requestID == "" {
+		return nil, fmt.Errorf("request_id is required")
+	}
+
+	return map[string]interface{}{
+		"request_id": requestID,
+		"cancelled":  da.cancelInFlightCommand(requestID),
+	}, nil
+}
+
+// deployContainerCommand adapts deployContainer to the executeCommand
+// result/error convention other handlers use.
+func (da *DockerAgent) deployContainerCommand(ctx context.Context, command map[string]interface{}) (map[string]interface{}, error) {
+	return da.deployContainer(ctx, command)
+}
+
+// startContainer starts a Docker container
+func (da *DockerAgent) startContainer(ctx context.Context, command map[string]interface{}) (map[string]interface{}, error) {
+	containerID, ok := command["container_id"].(string)
+	if !ok {
+		return nil, fmt.Errorf("container_id is required")
+	}
+
+	if err := da.dockerClient.ContainerStart(ctx, containerID, types.ContainerStartOptions{}); err != nil {
+		return nil, err
+	}
+
+	return map[string]interface{}{
+		"container_id": containerID,
+		"action":       "started",
+	}, nil
+}
 
 // stopContainer stops a Docker container
 func (da *DockerAgent) stopContainer(ctx context.Context, command map[string]interface{}) (map[string]interface{}, error) {
@@ -785,8 +2053,7 @@ func (da *DockerAgent) stopContainer(ctx context.Context, command map[string]int
 		timeout = int(t)
 	}
 
-	timeoutDuration := time.Duration(timeout) * time.Second
-	if err := da.dockerClient.ContainerStop(ctx, containerID, &timeoutDuration); err != nil {
+	if err := da.dockerClient.ContainerStop(ctx, containerID, container.StopOptions{Timeout: &timeout}); err != nil {
 		return nil, err
 	}
 
@@ -808,8 +2075,7 @@ func (da *DockerAgent) restartContainer(ctx context.Context, command map[string]
 		timeout = int(t)
 	}
 
-	timeoutDuration := time.Duration(timeout) * time.Second
-	if err := da.dockerClient.ContainerRestart(ctx, containerID, &timeoutDuration); err != nil {
+	if err := da.dockerClient.ContainerRestart(ctx, containerID, container.StopOptions{Timeout: &timeout}); err != nil {
 		return nil, err
 	}
 
@@ -844,6 +2110,65 @@ func (da *DockerAgent) removeContainer(ctx context.Context, command map[string]i
 	}, nil
 }
 
+// pullCoordinator dedups concurrent pulls of the same image, so the server
+// queuing several pull commands for the same reference doesn't open
+// redundant connections to the registry. A request for an image that's
+// already being pulled attaches to the in-flight pull's result instead of
+// starting a new one.
+type pullCoordinator struct {
+	mu     sync.Mutex
+	active map[string]*activePull
+}
+
+// activePull tracks one in-flight pull that other callers can attach to.
+type activePull struct {
+	done   chan struct{}
+	result map[string]interface{}
+	err    error
+	cancel context.CancelFunc
+}
+
+func newPullCoordinator() *pullCoordinator {
+	return &pullCoordinator{active: make(map[string]*activePull)}
+}
+
+// pull runs run() for key if no pull for that key is already in flight,
+// otherwise it waits for the existing pull to finish and returns its result.
+func (pc *pullCoordinator) pull(ctx context.Context, key string, run func(ctx context.Context) (map[string]interface{}, error)) (map[string]interface{}, error) {
+	pc.mu.Lock()
+	if existing, ok := pc.active[key]; ok {
+		pc.mu.Unlock()
+		<-existing.done
+		return existing.result, existing.err
+	}
+
+	pullCtx, cancel := context.WithCancel(ctx)
+	ap := &activePull{done: make(chan struct{}), cancel: cancel}
+	pc.active[key] = ap
+	pc.mu.Unlock()
+
+	ap.result, ap.err = run(pullCtx)
+	close(ap.done)
+
+	pc.mu.Lock()
+	delete(pc.active, key)
+	pc.mu.Unlock()
+
+	return ap.result, ap.err
+}
+
+// cancel stops the in-flight pull for key, if any, and reports whether one
+// was found.
+func (pc *pullCoordinator) cancel(key string) bool {
+	pc.mu.Lock()
+	defer pc.mu.Unlock()
+	ap, ok := pc.active[key]
+	if ok {
+		ap.cancel()
+	}
+	return ok
+}
+
 // pullImage pulls a Docker image
 func (da *DockerAgent) pullImage(ctx context.Context, command map[string]interface{}) (map[string]interface{}, error) {
 	imageName, ok := command["image"].(string)
@@ -851,21 +2176,153 @@ func (da *DockerAgent) pullImage(ctx context.Context, command map[string]interfa
 		return nil, fmt.Errorf("image name is required")
 	}
 
-	reader, err := da.dockerClient.ImagePull(ctx, imageName, types.ImagePullOptions{})
+	streamID, _ := command["stream_id"].(string)
+
+	registryAuth, err := da.resolveRegistryAuth(command)
 	if err != nil {
 		return nil, err
 	}
-	defer reader.Close()
 
-	// Read the pull output (optional, for logging)
-	_, err = io.Copy(io.Discard, reader)
+	requestedPlatform, err := parseRequestedPlatform(command)
+	if err != nil {
+		return nil, err
+	}
+	var platform string
+	if requestedPlatform != nil {
+		platform = platforms.Format(*requestedPlatform)
+	}
+
+	timeout := da.config.PullTimeoutSeconds
+	if timeout <= 0 {
+		timeout = 600
+	}
+	pullCtx, cancel := context.WithTimeout(ctx, time.Duration(timeout)*time.Second)
+	defer cancel()
+
+	return da.pulls.pull(pullCtx, imageName, func(ctx context.Context) (map[string]interface{}, error) {
+		return da.runImagePull(ctx, imageName, registryAuth, platform, streamID)
+	})
+}
+
+// runImagePull performs the actual ImagePull call, forwarding Docker's
+// streamed progress as {layer_id, current, total, status} frames over the
+// command channel (or periodic POSTs as a fallback) as it goes.
+func (da *DockerAgent) runImagePull(ctx context.Context, imageName, registryAuth, platform, streamID string) (map[string]interface{}, error) {
+	reader, err := da.dockerClient.ImagePull(ctx, imageName, types.ImagePullOptions{RegistryAuth: registryAuth, Platform: platform})
 	if err != nil {
 		return nil, err
 	}
+	defer reader.Close()
+
+	decoder := json.NewDecoder(reader)
+	var lastStatus string
+	for {
+		var progress struct {
+			Status         string `json:"status"`
+			ID             string `json:"id"`
+			ProgressDetail struct {
+				Current int64 `json:"current"`
+				Total   int64 `json:"total"`
+			} `json:"progressDetail"`
+		}
+
+		if err := decoder.Decode(&progress); err != nil {
+			if err == io.EOF {
+				break
+			}
+			return nil, err
+		}
+
+		lastStatus = progress.Status
+		da.reportPullProgress(streamID, map[string]interface{}{
+			"layer_id": progress.ID,
+			"current":  progress.ProgressDetail.Current,
+			"total":    progress.ProgressDetail.Total,
+			"status":   progress.Status,
+		})
+	}
 
 	return map[string]interface{}{
-		"image":  imageName,
-		"action": "pulled",
+		"image":       imageName,
+		"action":      "pulled",
+		"last_status": lastStatus,
+	}, nil
+}
+
+// reportPullProgress forwards a pull progress frame over the command
+// channel when it's connected, or falls back to throttled HTTP POSTs (at
+// most once per second per stream) so a layer-by-layer pull doesn't flood
+// the server with requests.
+func (da *DockerAgent) reportPullProgress(streamID string, frame map[string]interface{}) {
+	frame["stream_id"] = streamID
+
+	if conn, writeMu := da.commandConn(); conn != nil {
+		if err := writeStreamFrame(conn, writeMu, frame); err == nil {
+			return
+		}
+	}
+
+	da.progressMu.Lock()
+	last, sentBefore := da.lastProgressSent[streamID]
+	now := time.Now()
+	if sentBefore && now.Sub(last) < time.Second {
+		da.progressMu.Unlock()
+		return
+	}
+	da.lastProgressSent[streamID] = now
+	da.progressMu.Unlock()
+
+	if err := da.sendToServer("/api/v1/agents/pull-progress", frame); err != nil {
+		log.Printf("Failed to report pull progress: %v", err)
+	}
+}
+
+// resolveRegistryAuth turns a command's optional registry_auth object into
+// the base64-encoded value types.ImagePullOptions.RegistryAuth expects.
+// registry_auth may carry raw username/password/identitytoken fields, or a
+// credential_id that's looked up in Config.CredentialStore.
+func (da *DockerAgent) resolveRegistryAuth(command map[string]interface{}) (string, error) {
+	authField, ok := command["registry_auth"].(map[string]interface{})
+	if !ok {
+		return "", nil
+	}
+
+	var cred RegistryCredential
+	if credID, ok := authField["credential_id"].(string); ok && credID != "" {
+		stored, ok := da.config.CredentialStore[credID]
+		if !ok {
+			return "", fmt.Errorf("unknown credential_id: %s", credID)
+		}
+		cred = stored
+	} else {
+		cred.Username, _ = authField["username"].(string)
+		cred.Password, _ = authField["password"].(string)
+		cred.IdentityToken, _ = authField["identitytoken"].(string)
+	}
+
+	authJSON, err := json.Marshal(map[string]string{
+		"username":      cred.Username,
+		"password":      cred.Password,
+		"identitytoken": cred.IdentityToken,
+	})
+	if err != nil {
+		return "", err
+	}
+
+	return base64.URLEncoding.EncodeToString(authJSON), nil
+}
+
+// cancelPull cancels an in-flight image pull started by pullImage, keyed by
+// the same image reference.
+func (da *DockerAgent) cancelPull(ctx context.Context, command map[string]interface{}) (map[string]interface{}, error) {
+	imageName, ok := command["image"].(string)
+	if !ok {
+		return nil, fmt.Errorf("image is required")
+	}
+
+	return map[string]interface{}{
+		"image":     imageName,
+		"cancelled": da.pulls.cancel(imageName),
 	}, nil
 }
 
@@ -945,48 +2402,274 @@ func (da *DockerAgent) systemPrune(ctx context.Context, command map[string]inter
 	}
 
 	if pruneContainers {
-		containerReport, err := da.dockerClient.ContainersPrune(ctx, filters.Args{})
+		report, err := da.containersPrune(ctx, command)
 		if err != nil {
 			return nil, fmt.Errorf("failed to prune containers: %v", err)
 		}
-		result["containers_deleted"] = containerReport.ContainersDeleted
-		result["containers_space_reclaimed"] = containerReport.SpaceReclaimed
+		result["containers"] = report
 	}
 
 	if pruneImages {
-		imageReport, err := da.dockerClient.ImagesPrune(ctx, filters.Args{})
+		report, err := da.imagesPrune(ctx, command)
 		if err != nil {
 			return nil, fmt.Errorf("failed to prune images: %v", err)
 		}
-		result["images_deleted"] = imageReport.ImagesDeleted
-		result["images_space_reclaimed"] = imageReport.SpaceReclaimed
+		result["images"] = report
 	}
 
 	if pruneVolumes {
-		volumeReport, err := da.dockerClient.VolumesPrune(ctx, filters.Args{})
+		report, err := da.volumesPrune(ctx, command)
 		if err != nil {
 			return nil, fmt.Errorf("failed to prune volumes: %v", err)
 		}
-		result["volumes_deleted"] = volumeReport.VolumesDeleted
-		result["volumes_space_reclaimed"] = volumeReport.SpaceReclaimed
+		result["volumes"] = report
 	}
 
 	if pruneNetworks {
-		networkReport, err := da.dockerClient.NetworksPrune(ctx, filters.Args{})
+		report, err := da.networksPrune(ctx, command)
 		if err != nil {
 			return nil, fmt.Errorf("failed to prune networks: %v", err)
 		}
-		result["networks_deleted"] = networkReport.NetworksDeleted
+		result["networks"] = report
+	}
+
+	return result, nil
+}
+
+// pruneFilterKeys lists the filter keys the Docker Engine prune API accepts
+// per resource, so buildPruneFilters can drop anything a resource doesn't
+// support instead of passing it through and letting the daemon reject it.
+var pruneFilterKeys = map[string][]string{
+	"containers": {"until", "label", "label!"},
+	"images":     {"until", "label", "label!", "dangling"},
+	"volumes":    {"label", "label!"},
+	"networks":   {"until", "label", "label!"},
+}
+
+// buildPruneFilters translates a command's "filters" map into filters.Args,
+// keeping only the keys the given resource's prune API accepts. A filter
+// value may be a single string or a list of strings (e.g. multiple label
+// selectors); bools are rendered as "true"/"false" (e.g. dangling=true).
+func buildPruneFilters(command map[string]interface{}, resource string) filters.Args {
+	args := filters.NewArgs()
+
+	rawFilters, ok := command["filters"].(map[string]interface{})
+	if !ok {
+		return args
+	}
+
+	allowed := make(map[string]bool)
+	for _, key := range pruneFilterKeys[resource] {
+		allowed[key] = true
+	}
+
+	for key, value := range rawFilters {
+		if !allowed[key] {
+			continue
+		}
+
+		switch v := value.(type) {
+		case string:
+			args.Add(key, v)
+		case bool:
+			args.Add(key, strconv.FormatBool(v))
+		case []interface{}:
+			for _, item := range v {
+				if s, ok := item.(string); ok {
+					args.Add(key, s)
+				}
+			}
+		}
+	}
+
+	return args
+}
+
+// isDryRun reports whether a prune command asked to preview candidates
+// instead of actually deleting them.
+func isDryRun(command map[string]interface{}) bool {
+	dryRun, _ := command["dry_run"].(bool)
+	return dryRun
+}
+
+// containersPrune removes stopped containers matching the command's
+// filters ("until", "label"/"label!"), or just lists the candidates when
+// dry_run is set.
+func (da *DockerAgent) containersPrune(ctx context.Context, command map[string]interface{}) (map[string]interface{}, error) {
+	pruneFilters := buildPruneFilters(command, "containers")
+
+	if isDryRun(command) {
+		candidates, err := da.dockerClient.ContainerList(ctx, types.ContainerListOptions{All: true, Filters: pruneFilters})
+		if err != nil {
+			return nil, err
+		}
+		var ids []string
+		for _, c := range candidates {
+			if c.State == "exited" || c.State == "created" {
+				ids = append(ids, shortID(c.ID))
+			}
+		}
+		return map[string]interface{}{"dry_run": true, "candidates": ids}, nil
+	}
+
+	report, err := da.dockerClient.ContainersPrune(ctx, pruneFilters)
+	if err != nil {
+		return nil, err
+	}
+	return map[string]interface{}{
+		"containers_deleted": report.ContainersDeleted,
+		"space_reclaimed":    report.SpaceReclaimed,
+	}, nil
+}
+
+// imagesPrune removes unused images matching the command's filters
+// ("until", "label"/"label!", "dangling"), or just lists the candidates
+// when dry_run is set.
+func (da *DockerAgent) imagesPrune(ctx context.Context, command map[string]interface{}) (map[string]interface{}, error) {
+	pruneFilters := buildPruneFilters(command, "images")
+
+	if isDryRun(command) {
+		candidates, err := da.dockerClient.ImageList(ctx, types.ImageListOptions{All: true, Filters: pruneFilters})
+		if err != nil {
+			return nil, err
+		}
+		var ids []string
+		for _, img := range candidates {
+			ids = append(ids, shortID(img.ID))
+		}
+		return map[string]interface{}{"dry_run": true, "candidates": ids}, nil
+	}
+
+	report, err := da.dockerClient.ImagesPrune(ctx, pruneFilters)
+	if err != nil {
+		return nil, err
+	}
+	return map[string]interface{}{
+		"images_deleted":  report.ImagesDeleted,
+		"space_reclaimed": report.SpaceReclaimed,
+	}, nil
+}
+
+// volumesPrune removes unused volumes matching the command's filters
+// ("label"/"label!"), or just lists the candidates when dry_run is set.
+func (da *DockerAgent) volumesPrune(ctx context.Context, command map[string]interface{}) (map[string]interface{}, error) {
+	pruneFilters := buildPruneFilters(command, "volumes")
+
+	if isDryRun(command) {
+		candidates, err := da.dockerClient.VolumeList(ctx, volume.ListOptions{Filters: pruneFilters})
+		if err != nil {
+			return nil, err
+		}
+		var names []string
+		for _, v := range candidates.Volumes {
+			names = append(names, v.Name)
+		}
+		return map[string]interface{}{"dry_run": true, "candidates": names}, nil
+	}
+
+	report, err := da.dockerClient.VolumesPrune(ctx, pruneFilters)
+	if err != nil {
+		return nil, err
+	}
+	return map[string]interface{}{
+		"volumes_deleted": report.VolumesDeleted,
+		"space_reclaimed": report.SpaceReclaimed,
+	}, nil
+}
+
+// networksPrune removes unused networks matching the command's filters
+// ("until", "label"/"label!"), or just lists the candidates when dry_run
+// is set.
+func (da *DockerAgent) networksPrune(ctx context.Context, command map[string]interface{}) (map[string]interface{}, error) {
+	pruneFilters := buildPruneFilters(command, "networks")
+
+	if isDryRun(command) {
+		candidates, err := da.dockerClient.NetworkList(ctx, types.NetworkListOptions{Filters: pruneFilters})
+		if err != nil {
+			return nil, err
+		}
+		var names []string
+		for _, n := range candidates {
+			names = append(names, n.Name)
+		}
+		return map[string]interface{}{"dry_run": true, "candidates": names}, nil
+	}
+
+	report, err := da.dockerClient.NetworksPrune(ctx, pruneFilters)
+	if err != nil {
+		return nil, err
+	}
+	return map[string]interface{}{
+		"networks_deleted": report.NetworksDeleted,
+	}, nil
+}
+
+// composeUp brings up a Compose project's services.
+func (da *DockerAgent) composeUp(ctx context.Context, command map[string]interface{}) (map[string]interface{}, error) {
+	return da.runComposeCommand(ctx, command, "up", "-d")
+}
+
+// composeDown tears down a Compose project's services and default network.
+func (da *DockerAgent) composeDown(ctx context.Context, command map[string]interface{}) (map[string]interface{}, error) {
+	return da.runComposeCommand(ctx, command, "down")
+}
+
+// composeRestart restarts a Compose project's services.
+func (da *DockerAgent) composeRestart(ctx context.Context, command map[string]interface{}) (map[string]interface{}, error) {
+	return da.runComposeCommand(ctx, command, "restart")
+}
+
+// composePull pulls the images referenced by a Compose project.
+func (da *DockerAgent) composePull(ctx context.Context, command map[string]interface{}) (map[string]interface{}, error) {
+	return da.runComposeCommand(ctx, command, "pull")
+}
+
+// composePs lists the containers belonging to a Compose project.
+func (da *DockerAgent) composePs(ctx context.Context, command map[string]interface{}) (map[string]interface{}, error) {
+	return da.runComposeCommand(ctx, command, "ps")
+}
+
+// runComposeCommand shells out to `docker compose` for the compose.yaml path
+// given in the command payload, streaming stdout/stderr back in the result
+// so the server can surface progress to the user.
+func (da *DockerAgent) runComposeCommand(ctx context.Context, command map[string]interface{}, args ...string) (map[string]interface{}, error) {
+	composeFile, ok := command["compose_file"].(string)
+	if !ok || composeFile == "" {
+		return nil, fmt.Errorf("compose_file is required")
+	}
+
+	if service, ok := command["service"].(string); ok && service != "" {
+		args = append(args, service)
+	}
+
+	cmdArgs := append([]string{"compose", "-f", composeFile}, args...)
+	cmd := exec.CommandContext(ctx, "docker", cmdArgs...)
+
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+
+	runErr := cmd.Run()
+
+	result := map[string]interface{}{
+		"compose_file": composeFile,
+		"command":      cmdArgs,
+		"stdout":       stdout.String(),
+		"stderr":       stderr.String(),
+	}
+
+	if runErr != nil {
+		return result, fmt.Errorf("docker compose %v failed: %v", args, runErr)
 	}
 
 	return result, nil
 }
 
 // deployContainer deploys a new container with specified configuration
-func (da *DockerAgent) deployContainer(ctx context.Context, config map[string]interface{}) error {
+func (da *DockerAgent) deployContainer(ctx context.Context, config map[string]interface{}) (map[string]interface{}, error) {
 	imageName, ok := config["image"].(string)
 	if !ok {
-		return fmt.Errorf("image name is required")
+		return nil, fmt.Errorf("image name is required")
 	}
 
 	containerName, _ := config["name"].(string)
@@ -994,18 +2677,47 @@ func (da *DockerAgent) deployContainer(ctx context.Context, config map[string]in
 		containerName = fmt.Sprintf("sams-deployed-%d", time.Now().Unix())
 	}
 
+	registryAuth, err := da.resolveRegistryAuth(config)
+	if err != nil {
+		return nil, err
+	}
+
+	requestedPlatform, err := parseRequestedPlatform(config)
+	if err != nil {
+		return nil, err
+	}
+	var pullPlatform string
+	if requestedPlatform != nil {
+		pullPlatform = platforms.Format(*requestedPlatform)
+	}
+
 	// Pull image if not exists
-	_, _, err := da.dockerClient.ImageInspectWithRaw(ctx, imageName)
+	_, _, err = da.dockerClient.ImageInspectWithRaw(ctx, imageName)
 	if err != nil {
 		log.Printf("Image %s not found locally, pulling...", imageName)
-		reader, err := da.dockerClient.ImagePull(ctx, imageName, types.ImagePullOptions{})
+		reader, err := da.dockerClient.ImagePull(ctx, imageName, types.ImagePullOptions{RegistryAuth: registryAuth, Platform: pullPlatform})
 		if err != nil {
-			return fmt.Errorf("failed to pull image: %v", err)
+			return nil, fmt.Errorf("failed to pull image: %v", err)
 		}
 		defer reader.Close()
 		io.Copy(io.Discard, reader)
 	}
 
+	matched, resolvedPlatform, err := da.checkImagePlatform(ctx, imageName, requestedPlatform)
+	if err != nil {
+		return nil, fmt.Errorf("failed to resolve image platform: %v", err)
+	}
+	if !matched {
+		da.sendPlatformMismatchAlert(imageName, *requestedPlatform, resolvedPlatform)
+		return nil, fmt.Errorf("refusing to deploy %s: requested platform %s does not match resolved platform %s",
+			imageName, platforms.Format(*requestedPlatform), platforms.Format(resolvedPlatform))
+	}
+
+	trust, err := da.verifyImageTrust(ctx, imageName)
+	if err != nil {
+		return nil, fmt.Errorf("refusing to deploy untrusted image %s: %v", imageName, err)
+	}
+
 	// Parse environment variables
 	var env []string
 	if envVars, ok := config["environment"].(map[string]interface{}); ok {
@@ -1047,50 +2759,35 @@ func (da *DockerAgent) deployContainer(ctx context.Context, config map[string]in
 		},
 	}
 
-	resp, err := da.dockerClient.ContainerCreate(ctx, containerConfig, hostConfig, nil, nil, containerName)
+	resp, err := da.dockerClient.ContainerCreate(ctx, containerConfig, hostConfig, nil, requestedPlatform, containerName)
 	if err != nil {
-		return fmt.Errorf("failed to create container: %v", err)
+		return nil, fmt.Errorf("failed to create container: %v", err)
 	}
 
 	// Start container
 	if err := da.dockerClient.ContainerStart(ctx, resp.ID, types.ContainerStartOptions{}); err != nil {
-		return fmt.Errorf("failed to start container: %v", err)
+		return nil, fmt.Errorf("failed to start container: %v", err)
 	}
 
-	log.Printf("Successfully deployed container %s (ID: %s)", containerName, resp.ID[:12])
-	return nil
+	log.Printf("Successfully deployed container %s (ID: %s)", containerName, shortID(resp.ID))
+	return map[string]interface{}{
+		"name":         containerName,
+		"image":        imageName,
+		"container_id": resp.ID,
+		"action":       "deployed",
+		"trust":        trust,
+	}, nil
 }
 
-// sendToServer sends data to the SAMS server
+// sendToServer sends data to the SAMS server over da.transport, defaulting
+// to plain HTTP+JSON if no transport was configured (e.g. in tests that
+// construct a DockerAgent directly rather than through NewDockerAgent).
 func (da *DockerAgent) sendToServer(endpoint string, data interface{}) error {
-	jsonData, err := json.Marshal(data)
-	if err != nil {
-		return fmt.Errorf("failed to marshal data: %v", err)
-	}
-
-	url := da.config.ServerURL + endpoint
-	req, err := http.NewRequest("POST", url, bytes.NewBuffer(jsonData))
-	if err != nil {
-		return fmt.Errorf("failed to create request: %v", err)
-	}
-
-	req.Header.Set("Content-Type", "application/json")
-	if da.config.APIKey != "" {
-		req.Header.Set("Authorization", "Bearer "+da.config.APIKey)
-	}
-
-	resp, err := da.httpClient.Do(req)
-	if err != nil {
-		return fmt.Errorf("failed to send request: %v", err)
+	transport := da.transport
+	if transport == nil {
+		transport = newHTTPTransport(da)
 	}
-	defer resp.Body.Close()
-
-	if resp.StatusCode != http.StatusOK {
-		return fmt.Errorf("server returned status %d", resp.StatusCode)
-	}
-
-	log.Printf("Successfully sent data to %s", endpoint)
-	return nil
+	return transport.Send(context.Background(), endpoint, data)
 }
 
 func main() {
@@ -1105,10 +2802,11 @@ func main() {
 	}
 
 	// Start metrics collection
-	go agent.start()
-
-	// Start remote command handler
-	go agent.handleRemoteCommands()
+	go func() {
+		if err := agent.Start(); err != nil {
+			log.Printf("Agent stopped with error: %v", err)
+		}
+	}()
 
 	// Wait for interrupt signal
 	sigChan := make(chan os.Signal, 1)
@@ -1116,5 +2814,5 @@ func main() {
 	<-sigChan
 
 	log.Println("Shutting down Docker Agent...")
-	agent.stop()
+	agent.Stop()
 }
@@ -1,30 +1,57 @@
 package main
 
 import (
+	"bytes"
 	"context"
+	"encoding/base64"
 	"encoding/json"
+	"fmt"
 	"io"
 	"net/http"
 	"net/http/httptest"
 	"os"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
 	"github.com/docker/docker/api/types"
 	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/events"
 	"github.com/docker/docker/api/types/filters"
 	"github.com/docker/docker/api/types/network"
+	"github.com/docker/docker/api/types/volume"
 	"github.com/docker/docker/client"
-	"github.com/opencontainers/image-spec/specs-go/v1"
+	specs "github.com/opencontainers/image-spec/specs-go/v1"
 )
 
 // MockDockerClient implements a mock Docker client for testing
 type MockDockerClient struct {
 	containers []types.Container
 	images     []types.ImageSummary
+	volumes    []*volume.Volume
+	networks   []types.NetworkResource
 	info       types.Info
 	version    types.Version
+
+	inspectResult      *types.ContainerJSON
+	imageInspectResult types.ImageInspect
+	lastPlatform       *specs.Platform
+
+	// callLog records the order of lifecycle calls made against this mock,
+	// for tests asserting a specific sequence (e.g. Pull->Stop->Remove->
+	// Create->Start during a container recreate).
+	callLog []string
+
+	blockContainerLogs        chan struct{}
+	blockContainerLogsStarted chan struct{}
+
+	lastPruneFilters      filters.Args
+	containersPruneReport types.ContainersPruneReport
+	imagesPruneReport     types.ImagesPruneReport
+	volumesPruneReport    types.VolumesPruneReport
+	networksPruneReport   types.NetworksPruneReport
 }
 
 func (m *MockDockerClient) ContainerList(ctx context.Context, options types.ContainerListOptions) ([]types.Container, error) {
@@ -32,8 +59,15 @@ func (m *MockDockerClient) ContainerList(ctx context.Context, options types.Cont
 }
 
 func (m *MockDockerClient) ContainerStats(ctx context.Context, containerID string, stream bool) (types.ContainerStats, error) {
-	// Return mock stats
-	return types.ContainerStats{}, nil
+	statsJSON := types.StatsJSON{
+		Stats: types.Stats{
+			CPUStats:    types.CPUStats{CPUUsage: types.CPUUsage{TotalUsage: 200}, SystemUsage: 1000},
+			PreCPUStats: types.CPUStats{CPUUsage: types.CPUUsage{TotalUsage: 100}, SystemUsage: 500},
+			MemoryStats: types.MemoryStats{Usage: 1000000, Limit: 2000000, Stats: map[string]uint64{"cache": 100000}},
+		},
+	}
+	body, _ := json.Marshal(statsJSON)
+	return types.ContainerStats{Body: io.NopCloser(bytes.NewReader(body))}, nil
 }
 
 func (m *MockDockerClient) Info(ctx context.Context) (types.Info, error) {
@@ -48,12 +82,12 @@ func (m *MockDockerClient) ImageList(ctx context.Context, options types.ImageLis
 	return m.images, nil
 }
 
-func (m *MockDockerClient) VolumeList(ctx context.Context, options types.VolumeListOptions) (types.VolumeListResponse, error) {
-	return types.VolumeListResponse{}, nil
+func (m *MockDockerClient) VolumeList(ctx context.Context, options volume.ListOptions) (volume.ListResponse, error) {
+	return volume.ListResponse{Volumes: m.volumes}, nil
 }
 
 func (m *MockDockerClient) NetworkList(ctx context.Context, options types.NetworkListOptions) ([]types.NetworkResource, error) {
-	return []types.NetworkResource{}, nil
+	return m.networks, nil
 }
 
 func (m *MockDockerClient) Ping(ctx context.Context) (types.Ping, error) {
@@ -65,27 +99,38 @@ func (m *MockDockerClient) DiskUsage(ctx context.Context, options types.DiskUsag
 }
 
 func (m *MockDockerClient) ContainerInspect(ctx context.Context, containerID string) (types.ContainerJSON, error) {
+	if m.inspectResult != nil {
+		return *m.inspectResult, nil
+	}
 	return types.ContainerJSON{}, nil
 }
 
 func (m *MockDockerClient) ContainerStart(ctx context.Context, containerID string, options types.ContainerStartOptions) error {
+	m.callLog = append(m.callLog, "start")
 	return nil
 }
 
-func (m *MockDockerClient) ContainerStop(ctx context.Context, containerID string, timeout *time.Duration) error {
+func (m *MockDockerClient) ContainerStop(ctx context.Context, containerID string, options container.StopOptions) error {
+	m.callLog = append(m.callLog, "stop")
 	return nil
 }
 
-func (m *MockDockerClient) ContainerRestart(ctx context.Context, containerID string, timeout *time.Duration) error {
+func (m *MockDockerClient) ContainerRestart(ctx context.Context, containerID string, options container.StopOptions) error {
 	return nil
 }
 
 func (m *MockDockerClient) ContainerRemove(ctx context.Context, containerID string, options types.ContainerRemoveOptions) error {
+	m.callLog = append(m.callLog, "remove")
 	return nil
 }
 
 func (m *MockDockerClient) ImagePull(ctx context.Context, refStr string, options types.ImagePullOptions) (io.ReadCloser, error) {
-	return io.NopCloser(strings.NewReader("pull complete")), nil
+	m.callLog = append(m.callLog, "pull")
+	progress := `{"status":"Pulling fs layer","id":"abc123","progressDetail":{}}
+{"status":"Downloading","id":"abc123","progressDetail":{"current":512,"total":1024}}
+{"status":"Pull complete","id":"abc123","progressDetail":{}}
+`
+	return io.NopCloser(strings.NewReader(progress)), nil
 }
 
 func (m *MockDockerClient) ImageRemove(ctx context.Context, imageID string, options types.ImageRemoveOptions) ([]types.ImageDeleteResponseItem, error) {
@@ -93,31 +138,81 @@ func (m *MockDockerClient) ImageRemove(ctx context.Context, imageID string, opti
 }
 
 func (m *MockDockerClient) ContainerLogs(ctx context.Context, container string, options types.ContainerLogsOptions) (io.ReadCloser, error) {
+	if m.blockContainerLogs != nil {
+		close(m.blockContainerLogsStarted)
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-m.blockContainerLogs:
+		}
+	}
 	return io.NopCloser(strings.NewReader("test logs")), nil
 }
 
 func (m *MockDockerClient) ContainersPrune(ctx context.Context, pruneFilters filters.Args) (types.ContainersPruneReport, error) {
-	return types.ContainersPruneReport{}, nil
+	m.lastPruneFilters = pruneFilters
+	return m.containersPruneReport, nil
 }
 
 func (m *MockDockerClient) ImagesPrune(ctx context.Context, pruneFilters filters.Args) (types.ImagesPruneReport, error) {
-	return types.ImagesPruneReport{}, nil
+	m.lastPruneFilters = pruneFilters
+	return m.imagesPruneReport, nil
 }
 
 func (m *MockDockerClient) VolumesPrune(ctx context.Context, pruneFilters filters.Args) (types.VolumesPruneReport, error) {
-	return types.VolumesPruneReport{}, nil
+	m.lastPruneFilters = pruneFilters
+	return m.volumesPruneReport, nil
 }
 
 func (m *MockDockerClient) NetworksPrune(ctx context.Context, pruneFilters filters.Args) (types.NetworksPruneReport, error) {
-	return types.NetworksPruneReport{}, nil
+	m.lastPruneFilters = pruneFilters
+	return m.networksPruneReport, nil
 }
 
-func (m *MockDockerClient) ContainerCreate(ctx context.Context, config *container.Config, hostConfig *container.HostConfig, networkingConfig *network.NetworkingConfig, platform *specs.Platform, containerName string) (container.ContainerCreateCreatedBody, error) {
-	return container.ContainerCreateCreatedBody{ID: "test-container-id"}, nil
+func (m *MockDockerClient) ContainerCreate(ctx context.Context, config *container.Config, hostConfig *container.HostConfig, networkingConfig *network.NetworkingConfig, platform *specs.Platform, containerName string) (container.CreateResponse, error) {
+	m.callLog = append(m.callLog, "create")
+	m.lastPlatform = platform
+	return container.CreateResponse{ID: "test-container-id"}, nil
 }
 
 func (m *MockDockerClient) ImageInspectWithRaw(ctx context.Context, imageID string) (types.ImageInspect, []byte, error) {
-	return types.ImageInspect{}, []byte{}, nil
+	return m.imageInspectResult, []byte{}, nil
+}
+
+func (m *MockDockerClient) Events(ctx context.Context, options types.EventsOptions) (<-chan events.Message, <-chan error) {
+	msgCh := make(chan events.Message)
+	errCh := make(chan error)
+	close(msgCh)
+	close(errCh)
+	return msgCh, errCh
+}
+
+func (m *MockDockerClient) ContainerExecCreate(ctx context.Context, containerID string, config types.ExecConfig) (types.IDResponse, error) {
+	return types.IDResponse{ID: "test-exec-id"}, nil
+}
+
+func (m *MockDockerClient) ContainerExecAttach(ctx context.Context, execID string, config types.ExecStartCheck) (types.HijackedResponse, error) {
+	return types.HijackedResponse{}, fmt.Errorf("exec attach not supported by mock")
+}
+
+func (m *MockDockerClient) ContainerExecResize(ctx context.Context, execID string, options types.ResizeOptions) error {
+	return nil
+}
+
+func (m *MockDockerClient) NetworkCreate(ctx context.Context, name string, options types.NetworkCreate) (types.NetworkCreateResponse, error) {
+	return types.NetworkCreateResponse{ID: "test-network-id"}, nil
+}
+
+func (m *MockDockerClient) NetworkRemove(ctx context.Context, networkID string) error {
+	return nil
+}
+
+func (m *MockDockerClient) VolumeCreate(ctx context.Context, options volume.CreateOptions) (volume.Volume, error) {
+	return volume.Volume{Name: options.Name}, nil
+}
+
+func (m *MockDockerClient) VolumeRemove(ctx context.Context, volumeID string, force bool) error {
+	return nil
 }
 
 // Test configuration loading
@@ -217,11 +312,11 @@ func TestCollectMetrics(t *testing.T) {
 		info: types.Info{
 			ContainersRunning: 1,
 			ContainersStopped: 0,
-			Images:           5,
-			ServerVersion:    "20.10.0",
+			Images:            5,
+			ServerVersion:     "20.10.0",
 		},
 		version: types.Version{
-			Version:   "20.10.0",
+			Version:    "20.10.0",
 			APIVersion: "1.41",
 		},
 	}
@@ -246,6 +341,16 @@ func TestCollectMetrics(t *testing.T) {
 }
 
 // Test health checks
+// hasAlertType reports whether alerts contains one with the given alert_type.
+func hasAlertType(alerts []map[string]interface{}, alertType string) bool {
+	for _, alert := range alerts {
+		if t, ok := alert["alert_type"].(string); ok && t == alertType {
+			return true
+		}
+	}
+	return false
+}
+
 func TestPerformHealthChecks(t *testing.T) {
 	mockClient := &MockDockerClient{
 		info: types.Info{
@@ -258,41 +363,45 @@ func TestPerformHealthChecks(t *testing.T) {
 			AgentID: "test-agent",
 		},
 		dockerClient: mockClient,
+		statsSamples: make(map[string][]ContainerStats),
 	}
 
 	ctx := context.Background()
-	containerStats := []ContainerStats{
-		{
-			ID:            "container1",
-			Name:          "test-container",
-			CPUPercent:    85.0, // High CPU to trigger alert
-			MemoryPercent: 50.0,
-			MemoryUsage:   1000000,
-			MemoryLimit:   2000000,
-		},
+	highCPU := ContainerStats{
+		ID:            "container1",
+		Name:          "test-container",
+		CPUPercent:    85.0,
+		MemoryPercent: 50.0,
+		MemoryUsage:   1000000,
+		MemoryLimit:   2000000,
 	}
 
-	alerts, err := agent.performHealthChecks(ctx, containerStats)
+	// A single instantaneous spike shouldn't fire an alert yet (flap
+	// suppression): only 1 of up to 5 samples has breached the threshold.
+	alerts, err := agent.performHealthChecks(ctx, []ContainerStats{highCPU})
 	if err != nil {
-		t.Errorf("Health checks failed: %v", err)
+		t.Fatalf("Health checks failed: %v", err)
 	}
-
-	// Should have at least one alert for high CPU
-	if len(alerts) == 0 {
-		t.Error("Expected at least one alert for high CPU usage")
+	if hasAlertType(alerts, "high_cpu_usage") {
+		t.Error("Expected a single high-CPU sample not to trigger an alert")
 	}
 
-	// Check if the alert is for high CPU usage
-	found := false
-	for _, alert := range alerts {
-		if alertType, ok := alert["alert_type"].(string); ok && alertType == "high_cpu_usage" {
-			found = true
-			break
-		}
+	// Two more high samples bring the window to 3 of 3 breaches, meeting
+	// the sustained threshold.
+	alerts, err = agent.performHealthChecks(ctx, []ContainerStats{highCPU})
+	if err != nil {
+		t.Fatalf("Health checks failed: %v", err)
+	}
+	if hasAlertType(alerts, "high_cpu_usage") {
+		t.Error("Expected 2 of 5 high-CPU samples not to trigger an alert yet")
 	}
 
-	if !found {
-		t.Error("Expected high CPU usage alert not found")
+	alerts, err = agent.performHealthChecks(ctx, []ContainerStats{highCPU})
+	if err != nil {
+		t.Fatalf("Health checks failed: %v", err)
+	}
+	if !hasAlertType(alerts, "high_cpu_usage") {
+		t.Error("Expected 3 of 5 high-CPU samples to trigger a sustained high CPU usage alert")
 	}
 }
 
@@ -344,7 +453,280 @@ func TestExecuteCommand(t *testing.T) {
 	}
 }
 
-// Test HTTP server functionality
+// Test that cancelCommand cancels the context of a command tracked under
+// the same request_id, and reports when no such command is running.
+func TestCancelCommand(t *testing.T) {
+	agent := &DockerAgent{
+		config:         &Config{AgentID: "test-agent"},
+		commandCancels: make(map[string]context.CancelFunc),
+	}
+
+	_, cancel := context.WithCancel(context.Background())
+	var cancelled int32
+	agent.trackCommand("req-1", func() {
+		atomic.AddInt32(&cancelled, 1)
+		cancel()
+	})
+
+	result, err := agent.cancelCommand(context.Background(), map[string]interface{}{"request_id": "req-1"})
+	if err != nil {
+		t.Fatalf("cancelCommand failed: %v", err)
+	}
+	if result["cancelled"] != true {
+		t.Errorf("Expected cancelled=true, got %v", result["cancelled"])
+	}
+	if atomic.LoadInt32(&cancelled) != 1 {
+		t.Errorf("Expected the tracked cancel func to run once, got %d", cancelled)
+	}
+
+	result, err = agent.cancelCommand(context.Background(), map[string]interface{}{"request_id": "missing"})
+	if err != nil {
+		t.Fatalf("cancelCommand failed: %v", err)
+	}
+	if result["cancelled"] != false {
+		t.Errorf("Expected cancelled=false for an unknown request_id, got %v", result["cancelled"])
+	}
+
+	if _, err := agent.cancelCommand(context.Background(), map[string]interface{}{}); err == nil {
+		t.Error("Expected an error when request_id is missing")
+	}
+}
+
+// Test that executeCommand derives a cancellable context for a command
+// carrying a request_id, and that cancel_command aborts a blocked operation
+// instead of waiting for it to finish.
+func TestExecuteCommandCancellation(t *testing.T) {
+	mockClient := &MockDockerClient{
+		blockContainerLogs:        make(chan struct{}),
+		blockContainerLogsStarted: make(chan struct{}),
+	}
+	agent := &DockerAgent{
+		config:         &Config{AgentID: "test-agent"},
+		dockerClient:   mockClient,
+		httpClient:     &http.Client{},
+		commandCancels: make(map[string]context.CancelFunc),
+	}
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+	agent.config.ServerURL = server.URL
+
+	done := make(chan error, 1)
+	go func() {
+		done <- agent.executeCommand(context.Background(), map[string]interface{}{
+			"id":           "cmd-1",
+			"type":         "container_logs",
+			"request_id":   "req-cancel",
+			"container_id": "test-container",
+		})
+	}()
+
+	<-mockClient.blockContainerLogsStarted
+
+	result, err := agent.cancelCommand(context.Background(), map[string]interface{}{"request_id": "req-cancel"})
+	if err != nil {
+		t.Fatalf("cancelCommand failed: %v", err)
+	}
+	if result["cancelled"] != true {
+		t.Errorf("Expected cancelled=true, got %v", result["cancelled"])
+	}
+
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Errorf("Expected executeCommand to return nil after sendToServer reports the cancellation, got %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Expected cancel_command to unblock the in-flight getContainerLogs call")
+	}
+}
+
+// TestExecuteCommandIdempotency fires the same command ID twice and
+// expects the second delivery to return the cached result rather than
+// starting the container again.
+func TestExecuteCommandIdempotency(t *testing.T) {
+	mockClient := &MockDockerClient{}
+
+	idempotency, err := newCommandIdempotencyCache(t.TempDir() + "/idempotency.db")
+	if err != nil {
+		t.Fatalf("Failed to create idempotency cache: %v", err)
+	}
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	agent := &DockerAgent{
+		config:       &Config{AgentID: "test-agent", ServerURL: server.URL},
+		dockerClient: mockClient,
+		httpClient:   &http.Client{},
+		idempotency:  idempotency,
+	}
+
+	command := map[string]interface{}{
+		"id":           "cmd-123",
+		"type":         "container_start",
+		"container_id": "test-container",
+	}
+
+	ctx := context.Background()
+	if err := agent.executeCommand(ctx, command); err != nil {
+		t.Fatalf("First delivery failed: %v", err)
+	}
+	if err := agent.executeCommand(ctx, command); err != nil {
+		t.Fatalf("Redelivery failed: %v", err)
+	}
+
+	started := 0
+	for _, call := range mockClient.callLog {
+		if call == "start" {
+			started++
+		}
+	}
+	if started != 1 {
+		t.Errorf("Expected container to be started exactly once, got %d starts", started)
+	}
+}
+
+// TestExecuteCommandRejectsMalformed asserts that a command with a typed
+// request struct but missing required fields is rejected before it ever
+// reaches the Docker client.
+func TestExecuteCommandRejectsMalformed(t *testing.T) {
+	mockClient := &MockDockerClient{}
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	agent := &DockerAgent{
+		config:       &Config{AgentID: "test-agent", ServerURL: server.URL},
+		dockerClient: mockClient,
+		httpClient:   &http.Client{},
+	}
+
+	command := map[string]interface{}{
+		"id":   "cmd-456",
+		"type": "container_start",
+		// container_id deliberately omitted
+	}
+
+	if err := agent.executeCommand(context.Background(), command); err != nil {
+		t.Fatalf("executeCommand itself should not fail, got %v", err)
+	}
+
+	if len(mockClient.callLog) != 0 {
+		t.Errorf("Expected the Docker client not to be called for a malformed command, got calls %v", mockClient.callLog)
+	}
+}
+
+// Test that pullCoordinator dedups concurrent pulls of the same key
+func TestPullCoordinatorDedup(t *testing.T) {
+	pc := newPullCoordinator()
+
+	var calls int32
+	release := make(chan struct{})
+	run := func(ctx context.Context) (map[string]interface{}, error) {
+		atomic.AddInt32(&calls, 1)
+		<-release
+		return map[string]interface{}{"action": "pulled"}, nil
+	}
+
+	var wg sync.WaitGroup
+	results := make([]map[string]interface{}, 2)
+	for i := 0; i < 2; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			result, err := pc.pull(context.Background(), "nginx:latest", run)
+			if err != nil {
+				t.Errorf("Unexpected error from pull: %v", err)
+			}
+			results[i] = result
+		}(i)
+	}
+
+	time.Sleep(20 * time.Millisecond)
+	close(release)
+	wg.Wait()
+
+	if atomic.LoadInt32(&calls) != 1 {
+		t.Errorf("Expected run to be called once for duplicate pulls, got %d", calls)
+	}
+	if results[0]["action"] != "pulled" || results[1]["action"] != "pulled" {
+		t.Errorf("Expected both callers to see the shared result, got %v and %v", results[0], results[1])
+	}
+}
+
+// Test that cancel() stops an in-flight pull's context
+func TestPullCoordinatorCancel(t *testing.T) {
+	pc := newPullCoordinator()
+
+	started := make(chan struct{})
+	run := func(ctx context.Context) (map[string]interface{}, error) {
+		close(started)
+		<-ctx.Done()
+		return nil, ctx.Err()
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		_, err := pc.pull(context.Background(), "nginx:latest", run)
+		done <- err
+	}()
+
+	<-started
+	if !pc.cancel("nginx:latest") {
+		t.Fatal("Expected cancel to find the in-flight pull")
+	}
+
+	if err := <-done; err == nil {
+		t.Error("Expected pull to return an error once cancelled")
+	}
+
+	if pc.cancel("nginx:latest") {
+		t.Error("Expected a second cancel to find nothing in flight")
+	}
+}
+
+// Test resolving registry_auth into types.ImagePullOptions.RegistryAuth
+func TestResolveRegistryAuth(t *testing.T) {
+	agent := &DockerAgent{
+		config: &Config{
+			CredentialStore: map[string]RegistryCredential{
+				"docker-hub": {Username: "alice", Password: "hunter2"},
+			},
+		},
+	}
+
+	auth, err := agent.resolveRegistryAuth(map[string]interface{}{
+		"registry_auth": map[string]interface{}{"credential_id": "docker-hub"},
+	})
+	if err != nil {
+		t.Fatalf("Unexpected error resolving credential_id: %v", err)
+	}
+	decoded, _ := base64.URLEncoding.DecodeString(auth)
+	if !strings.Contains(string(decoded), "alice") {
+		t.Errorf("Expected decoded auth to contain username, got %s", decoded)
+	}
+
+	if _, err := agent.resolveRegistryAuth(map[string]interface{}{
+		"registry_auth": map[string]interface{}{"credential_id": "unknown"},
+	}); err == nil {
+		t.Error("Expected an error for an unknown credential_id")
+	}
+
+	if auth, err := agent.resolveRegistryAuth(map[string]interface{}{}); err != nil || auth != "" {
+		t.Errorf("Expected no auth when registry_auth is absent, got auth=%q err=%v", auth, err)
+	}
+}
+
+// Test that sendToServer's default (no transport configured) behaves as
+// plain HTTP+JSON. See TestTransportSend for the table test covering all
+// three Transport implementations directly.
 func TestSendToServer(t *testing.T) {
 	// Create test server
 	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -416,6 +798,159 @@ func TestCreateAlert(t *testing.T) {
 	}
 }
 
+// Test normalizing Docker events into alerts
+func TestEventToAlert(t *testing.T) {
+	agent := &DockerAgent{
+		config: &Config{AgentID: "test-agent"},
+	}
+
+	dieEvent := events.Message{
+		Type:   events.ContainerEventType,
+		Action: "die",
+		Actor: events.Actor{
+			ID: "container1",
+			Attributes: map[string]string{
+				"name":     "test-container",
+				"exitCode": "1",
+			},
+		},
+	}
+
+	alert := agent.eventToAlert(dieEvent)
+	if alert == nil {
+		t.Fatal("Expected an alert for a non-zero exit die event")
+	}
+	if alert["alert_type"] != "container_exited" {
+		t.Errorf("Expected alert_type 'container_exited', got '%v'", alert["alert_type"])
+	}
+
+	agent.config.EventFilters.OnlyNonZeroExitDie = true
+	cleanExit := dieEvent
+	cleanExit.Actor.Attributes = map[string]string{"name": "test-container", "exitCode": "0"}
+	if alert := agent.eventToAlert(cleanExit); alert != nil {
+		t.Errorf("Expected no alert for a clean exit when OnlyNonZeroExitDie is set, got %v", alert)
+	}
+}
+
+// Test grouping containers by Compose project/service labels
+func TestGroupComposeProjects(t *testing.T) {
+	containers := []ContainerInfo{
+		{ID: "c1", State: "running", ComposeProject: "myapp", ComposeService: "web"},
+		{ID: "c2", State: "exited", ComposeProject: "myapp", ComposeService: "web"},
+		{ID: "c3", State: "running", ComposeProject: "myapp", ComposeService: "db"},
+		{ID: "c4", State: "running"},
+	}
+
+	projects := groupComposeProjects(containers)
+	if len(projects) != 1 {
+		t.Fatalf("Expected 1 compose project, got %d", len(projects))
+	}
+
+	web := projects["myapp"].Services["web"]
+	if web.RunningCount != 1 || len(web.Containers) != 2 {
+		t.Errorf("Expected web service with 1 running of 2 containers, got running=%d total=%d", web.RunningCount, len(web.Containers))
+	}
+}
+
+// Test compose service under-replication alerting
+func TestCheckComposeReplicas(t *testing.T) {
+	agent := &DockerAgent{
+		config: &Config{
+			AgentID:         "test-agent",
+			ComposeReplicas: map[string]int{"myapp/web": 3},
+		},
+	}
+
+	projects := map[string]*ComposeProjectInfo{
+		"myapp": {
+			Project: "myapp",
+			Services: map[string]*ComposeServiceInfo{
+				"web": {RunningCount: 1},
+			},
+		},
+	}
+
+	alerts := agent.checkComposeReplicas(projects)
+	if len(alerts) != 1 {
+		t.Fatalf("Expected 1 under-replication alert, got %d", len(alerts))
+	}
+	if alerts[0]["alert_type"] != "compose_under_replicated" {
+		t.Errorf("Expected alert_type 'compose_under_replicated', got '%v'", alerts[0]["alert_type"])
+	}
+}
+
+// Test command-field helpers used by the exec/log-follow streaming handlers
+func TestResizeFields(t *testing.T) {
+	width, height, ok := resizeFields(map[string]interface{}{"width": float64(80), "height": float64(24)})
+	if !ok || width != 80 || height != 24 {
+		t.Errorf("Expected width=80 height=24 ok=true, got width=%d height=%d ok=%v", width, height, ok)
+	}
+
+	if _, _, ok := resizeFields(map[string]interface{}{"width": float64(80)}); ok {
+		t.Error("Expected ok=false when height is missing")
+	}
+}
+
+func TestStringField(t *testing.T) {
+	if v := stringField(map[string]interface{}{"since": "2024-01-01"}, "since"); v != "2024-01-01" {
+		t.Errorf("Expected '2024-01-01', got '%s'", v)
+	}
+	if v := stringField(map[string]interface{}{}, "since"); v != "" {
+		t.Errorf("Expected empty string for missing field, got '%s'", v)
+	}
+}
+
+// Test that acquireStream enforces Config.MaxConcurrentStreams
+func TestAcquireStreamLimit(t *testing.T) {
+	agent := &DockerAgent{streamSem: make(chan struct{}, 1)}
+
+	release, err := agent.acquireStream(context.Background())
+	if err != nil {
+		t.Fatalf("Expected first acquireStream to succeed, got %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if _, err := agent.acquireStream(ctx); err == nil {
+		t.Error("Expected second acquireStream to block until context deadline")
+	}
+
+	release()
+	release2, err := agent.acquireStream(context.Background())
+	if err != nil {
+		t.Fatalf("Expected acquireStream to succeed after release, got %v", err)
+	}
+	release2()
+}
+
+// Test Prometheus exposition rendering of a cached metrics snapshot
+func TestRenderPrometheusMetrics(t *testing.T) {
+	snapshot := metricsSnapshot{
+		containerStats: []ContainerStats{
+			{ID: "c1", Name: "web", Image: "nginx", State: "running", CPUPercent: 12.5, MemoryUsage: 1024, NetworkRx: 2048},
+		},
+		daemonUp: true,
+		alerts: []map[string]interface{}{
+			{"severity": "high", "alert_type": "high_cpu_usage"},
+		},
+	}
+
+	output := renderPrometheusMetrics(snapshot)
+
+	for _, want := range []string{
+		`docker_container_cpu_percent{name="web",id="c1",image="nginx"} 12.500000`,
+		`docker_container_memory_usage_bytes{name="web",id="c1",image="nginx"} 1024`,
+		`docker_container_network_rx_bytes_total{name="web",id="c1",image="nginx"} 2048`,
+		`docker_container_state{name="web",id="c1",state="running"} 1`,
+		"docker_daemon_up 1",
+		`docker_alerts_active{severity="high",type="high_cpu_usage"} 1`,
+	} {
+		if !strings.Contains(output, want) {
+			t.Errorf("Expected output to contain %q, got:\n%s", want, output)
+		}
+	}
+}
+
 // Benchmark tests
 func BenchmarkCollectMetrics(b *testing.B) {
 	mockClient := &MockDockerClient{
@@ -442,6 +977,133 @@ func BenchmarkCollectMetrics(b *testing.B) {
 }
 
 // Integration test (requires actual Docker daemon)
+// Test that buildPruneFilters keeps only the keys a resource's prune API
+// accepts, and handles string, list, and bool filter values.
+func TestBuildPruneFilters(t *testing.T) {
+	command := map[string]interface{}{
+		"filters": map[string]interface{}{
+			"until":    "24h",
+			"label":    []interface{}{"env=staging", "team=sre"},
+			"dangling": true,
+		},
+	}
+
+	containerFilters := buildPruneFilters(command, "containers")
+	if containerFilters.Len() != 2 {
+		t.Errorf("Expected 2 filter keys for containers (until, label), got %d", containerFilters.Len())
+	}
+
+	imageFilters := buildPruneFilters(command, "images")
+	if imageFilters.Len() != 3 {
+		t.Errorf("Expected 3 filter keys for images (until, label, dangling), got %d", imageFilters.Len())
+	}
+
+	volumeFilters := buildPruneFilters(command, "volumes")
+	if volumeFilters.Len() != 1 {
+		t.Errorf("Expected 1 filter key for volumes (label), got %d", volumeFilters.Len())
+	}
+
+	if empty := buildPruneFilters(map[string]interface{}{}, "containers"); empty.Len() != 0 {
+		t.Errorf("Expected no filters when command has none, got %d", empty.Len())
+	}
+}
+
+// Test that containersPrune lists candidates without deleting in dry-run
+// mode, and deletes via the Docker API otherwise.
+func TestContainersPrune(t *testing.T) {
+	mockClient := &MockDockerClient{
+		containers: []types.Container{
+			{ID: "c1", Names: []string{"/old"}, State: "exited"},
+		},
+		containersPruneReport: types.ContainersPruneReport{
+			ContainersDeleted: []string{"c1"},
+			SpaceReclaimed:    1024,
+		},
+	}
+	agent := &DockerAgent{config: &Config{}, dockerClient: mockClient}
+	ctx := context.Background()
+
+	dryResult, err := agent.containersPrune(ctx, map[string]interface{}{"dry_run": true})
+	if err != nil {
+		t.Fatalf("containersPrune dry run failed: %v", err)
+	}
+	candidates, _ := dryResult["candidates"].([]string)
+	if len(candidates) != 1 {
+		t.Errorf("Expected 1 dry-run candidate, got %d", len(candidates))
+	}
+
+	result, err := agent.containersPrune(ctx, map[string]interface{}{})
+	if err != nil {
+		t.Fatalf("containersPrune failed: %v", err)
+	}
+	if result["space_reclaimed"] != uint64(1024) {
+		t.Errorf("Expected space_reclaimed 1024, got %v", result["space_reclaimed"])
+	}
+}
+
+// Test that imagesPrune translates the dangling filter and reports deleted
+// images.
+func TestImagesPrune(t *testing.T) {
+	mockClient := &MockDockerClient{
+		images: []types.ImageSummary{{ID: "img1"}},
+		imagesPruneReport: types.ImagesPruneReport{
+			ImagesDeleted:  []types.ImageDeleteResponseItem{{Deleted: "img1"}},
+			SpaceReclaimed: 2048,
+		},
+	}
+	agent := &DockerAgent{config: &Config{}, dockerClient: mockClient}
+	ctx := context.Background()
+
+	command := map[string]interface{}{"filters": map[string]interface{}{"dangling": true}}
+
+	result, err := agent.imagesPrune(ctx, command)
+	if err != nil {
+		t.Fatalf("imagesPrune failed: %v", err)
+	}
+	if mockClient.lastPruneFilters.Len() != 1 {
+		t.Errorf("Expected dangling filter to reach ImagesPrune, got %d filter keys", mockClient.lastPruneFilters.Len())
+	}
+	if result["space_reclaimed"] != uint64(2048) {
+		t.Errorf("Expected space_reclaimed 2048, got %v", result["space_reclaimed"])
+	}
+}
+
+// Test that volumesPrune lists candidate volume names in dry-run mode.
+func TestVolumesPrune(t *testing.T) {
+	mockClient := &MockDockerClient{
+		volumes: []*volume.Volume{{Name: "orphan-vol"}},
+	}
+	agent := &DockerAgent{config: &Config{}, dockerClient: mockClient}
+	ctx := context.Background()
+
+	result, err := agent.volumesPrune(ctx, map[string]interface{}{"dry_run": true})
+	if err != nil {
+		t.Fatalf("volumesPrune dry run failed: %v", err)
+	}
+	candidates, _ := result["candidates"].([]string)
+	if len(candidates) != 1 || candidates[0] != "orphan-vol" {
+		t.Errorf("Expected candidate 'orphan-vol', got %v", candidates)
+	}
+}
+
+// Test that networksPrune reports networks deleted by the Docker API.
+func TestNetworksPrune(t *testing.T) {
+	mockClient := &MockDockerClient{
+		networksPruneReport: types.NetworksPruneReport{NetworksDeleted: []string{"net1"}},
+	}
+	agent := &DockerAgent{config: &Config{}, dockerClient: mockClient}
+	ctx := context.Background()
+
+	result, err := agent.networksPrune(ctx, map[string]interface{}{})
+	if err != nil {
+		t.Fatalf("networksPrune failed: %v", err)
+	}
+	deleted, _ := result["networks_deleted"].([]string)
+	if len(deleted) != 1 || deleted[0] != "net1" {
+		t.Errorf("Expected networks_deleted ['net1'], got %v", deleted)
+	}
+}
+
 func TestIntegrationDockerConnection(t *testing.T) {
 	if testing.Short() {
 		t.Skip("Skipping integration test in short mode")
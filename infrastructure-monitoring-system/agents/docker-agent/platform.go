@@ -0,0 +1,56 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+
+	"github.com/containerd/containerd/platforms"
+	specs "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+// parseRequestedPlatform parses a command's optional "platform" field (e.g.
+// "linux/arm64/v8") into a *specs.Platform, or returns nil when the field
+// is absent so callers fall back to the daemon's default platform.
+func parseRequestedPlatform(command map[string]interface{}) (*specs.Platform, error) {
+	raw, ok := command["platform"].(string)
+	if !ok || raw == "" {
+		return nil, nil
+	}
+
+	platform, err := platforms.Parse(raw)
+	if err != nil {
+		return nil, fmt.Errorf("invalid platform %q: %v", raw, err)
+	}
+	return &platform, nil
+}
+
+// checkImagePlatform inspects imageName and reports whether it matches
+// requested. A nil requested always matches (no platform was asked for).
+func (da *DockerAgent) checkImagePlatform(ctx context.Context, imageName string, requested *specs.Platform) (matched bool, resolved specs.Platform, err error) {
+	inspect, _, err := da.dockerClient.ImageInspectWithRaw(ctx, imageName)
+	if err != nil {
+		return false, specs.Platform{}, err
+	}
+
+	resolved = specs.Platform{OS: inspect.Os, Architecture: inspect.Architecture, Variant: inspect.Variant}
+	if requested == nil {
+		return true, resolved, nil
+	}
+	return platforms.NewMatcher(*requested).Match(resolved), resolved, nil
+}
+
+// sendPlatformMismatchAlert reports a platform_mismatch alert through the
+// existing createAlert/sendToServer path, so the SAMS server can surface a
+// deploy that was refused instead of silently failing under qemu/
+// binfmt_misc emulation.
+func (da *DockerAgent) sendPlatformMismatchAlert(imageName string, requested, resolved specs.Platform) {
+	alert := da.createAlert("platform_mismatch", "high", fmt.Sprintf("Image %s does not match requested platform", imageName), map[string]interface{}{
+		"image":              imageName,
+		"requested_platform": platforms.Format(requested),
+		"resolved_platform":  platforms.Format(resolved),
+	})
+	if err := da.sendToServer("/api/v1/alerts", alert); err != nil {
+		log.Printf("Failed to send platform_mismatch alert: %v", err)
+	}
+}
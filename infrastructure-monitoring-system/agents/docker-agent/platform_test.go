@@ -0,0 +1,106 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/docker/docker/api/types"
+	specs "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+// Test that parseRequestedPlatform parses a well-formed platform string and
+// returns nil when the field is absent.
+func TestParseRequestedPlatform(t *testing.T) {
+	platform, err := parseRequestedPlatform(map[string]interface{}{"platform": "linux/arm64/v8"})
+	if err != nil {
+		t.Fatalf("Expected parsing to succeed, got: %v", err)
+	}
+	if platform == nil || platform.OS != "linux" || platform.Architecture != "arm64" || platform.Variant != "v8" {
+		t.Errorf("Unexpected platform: %+v", platform)
+	}
+
+	platform, err = parseRequestedPlatform(map[string]interface{}{})
+	if err != nil || platform != nil {
+		t.Errorf("Expected no platform requested, got platform=%v err=%v", platform, err)
+	}
+}
+
+// Test that a malformed platform string is rejected with a clear error
+// rather than silently ignored.
+func TestParseRequestedPlatformRejectsMalformed(t *testing.T) {
+	if _, err := parseRequestedPlatform(map[string]interface{}{"platform": "bogus"}); err == nil {
+		t.Error("Expected an error for a platform string missing an architecture")
+	}
+}
+
+// Test that checkImagePlatform matches when the resolved image platform
+// equals what was requested, and is a no-op match when nothing was requested.
+func TestCheckImagePlatform(t *testing.T) {
+	agent := &DockerAgent{
+		dockerClient: &MockDockerClient{
+			imageInspectResult: types.ImageInspect{Os: "linux", Architecture: "amd64"},
+		},
+	}
+
+	matched, resolved, err := agent.checkImagePlatform(context.Background(), "nginx:latest", nil)
+	if err != nil || !matched || resolved.Architecture != "amd64" {
+		t.Errorf("Expected a nil request to always match, got matched=%v resolved=%+v err=%v", matched, resolved, err)
+	}
+
+	requested := &specs.Platform{OS: "linux", Architecture: "arm64"}
+	matched, _, err = agent.checkImagePlatform(context.Background(), "nginx:latest", requested)
+	if err != nil || matched {
+		t.Errorf("Expected a mismatched architecture to fail, got matched=%v err=%v", matched, err)
+	}
+}
+
+// Test that deployContainer refuses to start a container whose resolved
+// image platform doesn't match what was requested.
+func TestDeployContainerRefusesPlatformMismatch(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	mock := &MockDockerClient{
+		imageInspectResult: types.ImageInspect{Os: "linux", Architecture: "arm64"},
+	}
+	agent := &DockerAgent{
+		config:       &Config{AgentID: "test-agent", ServerURL: server.URL},
+		dockerClient: mock,
+		httpClient:   &http.Client{},
+	}
+
+	_, err := agent.deployContainer(context.Background(), map[string]interface{}{
+		"image":    "nginx:latest",
+		"platform": "linux/amd64",
+	})
+	if err == nil {
+		t.Error("Expected deployContainer to refuse an image built for the wrong platform")
+	}
+	if mock.lastPlatform != nil {
+		t.Error("Expected ContainerCreate not to be reached after a platform mismatch")
+	}
+}
+
+// Test that deployContainer threads a matching requested platform through to
+// ContainerCreate.
+func TestDeployContainerPassesMatchingPlatform(t *testing.T) {
+	mock := &MockDockerClient{
+		imageInspectResult: types.ImageInspect{Os: "linux", Architecture: "amd64"},
+	}
+	agent := &DockerAgent{config: &Config{}, dockerClient: mock}
+
+	_, err := agent.deployContainer(context.Background(), map[string]interface{}{
+		"image":    "nginx:latest",
+		"platform": "linux/amd64",
+	})
+	if err != nil {
+		t.Fatalf("Expected deployContainer to succeed, got: %v", err)
+	}
+	if mock.lastPlatform == nil || mock.lastPlatform.Architecture != "amd64" {
+		t.Errorf("Expected the requested platform to reach ContainerCreate, got %v", mock.lastPlatform)
+	}
+}
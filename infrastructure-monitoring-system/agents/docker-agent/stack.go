@@ -0,0 +1,579 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/filters"
+	"github.com/docker/docker/api/types/volume"
+	"github.com/docker/go-connections/nat"
+)
+
+const (
+	stackHealthPollInterval = 2 * time.Second
+	stackHealthTimeout      = 2 * time.Minute
+)
+
+// stackService is one service entry parsed out of a deploy_stack command's
+// "services" map.
+type stackService struct {
+	name          string
+	image         string
+	env           []string
+	ports         map[string]interface{}
+	volumes       []string
+	dependsOn     []string
+	healthcheck   *container.HealthConfig
+	restartPolicy string
+	memoryBytes   int64
+	nanoCPUs      int64
+}
+
+// parseStackServices converts the raw "services" map of a deploy_stack
+// command into stackService values, keyed by service name.
+func parseStackServices(raw map[string]interface{}) (map[string]*stackService, error) {
+	services := make(map[string]*stackService, len(raw))
+
+	for name, v := range raw {
+		spec, ok := v.(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("service %s: spec must be an object", name)
+		}
+
+		image, ok := spec["image"].(string)
+		if !ok || image == "" {
+			return nil, fmt.Errorf("service %s: image is required", name)
+		}
+
+		svc := &stackService{name: name, image: image, restartPolicy: "unless-stopped"}
+
+		if envVars, ok := spec["environment"].(map[string]interface{}); ok {
+			for key, value := range envVars {
+				svc.env = append(svc.env, fmt.Sprintf("%s=%v", key, value))
+			}
+		}
+
+		if ports, ok := spec["ports"].(map[string]interface{}); ok {
+			svc.ports = ports
+		}
+
+		if volumes, ok := spec["volumes"].([]interface{}); ok {
+			for _, vol := range volumes {
+				if s, ok := vol.(string); ok {
+					svc.volumes = append(svc.volumes, s)
+				}
+			}
+		}
+
+		if dependsOn, ok := spec["depends_on"].([]interface{}); ok {
+			for _, dep := range dependsOn {
+				if s, ok := dep.(string); ok {
+					svc.dependsOn = append(svc.dependsOn, s)
+				}
+			}
+		}
+
+		if rp, ok := spec["restart_policy"].(string); ok && rp != "" {
+			svc.restartPolicy = rp
+		}
+
+		if hc, ok := spec["healthcheck"].(map[string]interface{}); ok {
+			svc.healthcheck = parseStackHealthcheck(hc)
+		}
+
+		if resources, ok := spec["resources"].(map[string]interface{}); ok {
+			if memMB, ok := resources["memory_mb"].(float64); ok {
+				svc.memoryBytes = int64(memMB) * 1024 * 1024
+			}
+			if cpus, ok := resources["cpus"].(float64); ok {
+				svc.nanoCPUs = int64(cpus * 1e9)
+			}
+		}
+
+		services[name] = svc
+	}
+
+	for name, svc := range services {
+		for _, dep := range svc.dependsOn {
+			if _, ok := services[dep]; !ok {
+				return nil, fmt.Errorf("service %s depends_on unknown service %s", name, dep)
+			}
+		}
+	}
+
+	return services, nil
+}
+
+// parseStackHealthcheck converts a healthcheck spec's "test" (a command
+// list) and interval/retries fields (in seconds) into a container.HealthConfig.
+func parseStackHealthcheck(hc map[string]interface{}) *container.HealthConfig {
+	var test []string
+	if raw, ok := hc["test"].([]interface{}); ok {
+		for _, t := range raw {
+			if s, ok := t.(string); ok {
+				test = append(test, s)
+			}
+		}
+	}
+	if len(test) == 0 {
+		return nil
+	}
+
+	intervalSeconds, _ := hc["interval"].(float64)
+	if intervalSeconds <= 0 {
+		intervalSeconds = 5
+	}
+	retries := 3
+	if r, ok := hc["retries"].(float64); ok && r > 0 {
+		retries = int(r)
+	}
+
+	return &container.HealthConfig{
+		Test:     test,
+		Interval: time.Duration(intervalSeconds * float64(time.Second)),
+		Retries:  retries,
+	}
+}
+
+// orderStackServices groups a stack's services into dependency levels via a
+// Kahn's-algorithm topological sort: level 0 has no depends_on, level N
+// depends only on services in levels < N. Services within a level start
+// concurrently; levels run in sequence so a service never starts before
+// what it depends on.
+func orderStackServices(services map[string]*stackService) ([][]string, error) {
+	remaining := make(map[string][]string, len(services))
+	for name, svc := range services {
+		remaining[name] = append([]string{}, svc.dependsOn...)
+	}
+
+	var levels [][]string
+	for len(remaining) > 0 {
+		var level []string
+		for name, deps := range remaining {
+			if len(deps) == 0 {
+				level = append(level, name)
+			}
+		}
+		if len(level) == 0 {
+			return nil, fmt.Errorf("circular depends_on among stack services")
+		}
+
+		for _, name := range level {
+			delete(remaining, name)
+		}
+		for name, deps := range remaining {
+			var next []string
+			for _, dep := range deps {
+				if !containsString(level, dep) {
+					next = append(next, dep)
+				}
+			}
+			remaining[name] = next
+		}
+
+		levels = append(levels, level)
+	}
+
+	return levels, nil
+}
+
+func containsString(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
+
+// deployStack brings up a compose-like set of services: a dedicated bridge
+// network and named volumes labeled with the stack name, images pulled in
+// parallel, then containers started in depends_on order, waiting for each
+// level's healthchecks before starting the next.
+func (da *DockerAgent) deployStack(ctx context.Context, command map[string]interface{}) (map[string]interface{}, error) {
+	stackName, ok := command["stack"].(string)
+	if !ok || stackName == "" {
+		return nil, fmt.Errorf("stack is required")
+	}
+
+	rawServices, ok := command["services"].(map[string]interface{})
+	if !ok || len(rawServices) == 0 {
+		return nil, fmt.Errorf("services is required")
+	}
+
+	services, err := parseStackServices(rawServices)
+	if err != nil {
+		return nil, err
+	}
+
+	levels, err := orderStackServices(services)
+	if err != nil {
+		return nil, err
+	}
+
+	networkName := stackNetworkName(stackName)
+	if _, err := da.dockerClient.NetworkCreate(ctx, networkName, types.NetworkCreate{
+		Driver: "bridge",
+		Labels: map[string]string{stackLabel: stackName},
+	}); err != nil {
+		return nil, fmt.Errorf("failed to create stack network: %v", err)
+	}
+
+	var volumeNames []string
+	if rawVolumes, ok := command["volumes"].([]interface{}); ok {
+		for _, v := range rawVolumes {
+			name, ok := v.(string)
+			if !ok || name == "" {
+				continue
+			}
+			if _, err := da.dockerClient.VolumeCreate(ctx, volume.CreateOptions{
+				Name:   name,
+				Labels: map[string]string{stackLabel: stackName},
+			}); err != nil {
+				return nil, fmt.Errorf("failed to create volume %s: %v", name, err)
+			}
+			volumeNames = append(volumeNames, name)
+		}
+	}
+
+	if err := da.pullStackImages(ctx, services); err != nil {
+		return nil, err
+	}
+
+	containerIDs := make(map[string]string)
+	var mu sync.Mutex
+
+	for _, level := range levels {
+		var wg sync.WaitGroup
+		errCh := make(chan error, len(level))
+
+		for _, name := range level {
+			svc := services[name]
+			wg.Add(1)
+			go func(svc *stackService) {
+				defer wg.Done()
+				id, err := da.startStackService(ctx, stackName, networkName, svc)
+				if err != nil {
+					errCh <- fmt.Errorf("service %s: %v", svc.name, err)
+					return
+				}
+				mu.Lock()
+				containerIDs[svc.name] = id
+				mu.Unlock()
+			}(svc)
+		}
+
+		wg.Wait()
+		close(errCh)
+		for err := range errCh {
+			return nil, err
+		}
+
+		for _, name := range level {
+			if services[name].healthcheck == nil {
+				continue
+			}
+			if err := da.waitForHealthy(ctx, containerIDs[name]); err != nil {
+				return nil, fmt.Errorf("service %s never became healthy: %v", name, err)
+			}
+		}
+	}
+
+	return map[string]interface{}{
+		"stack":      stackName,
+		"network":    networkName,
+		"volumes":    volumeNames,
+		"containers": containerIDs,
+		"action":     "stack_deployed",
+	}, nil
+}
+
+// pullStackImages pulls every distinct image referenced by a stack's
+// services in parallel, the same way deployContainer pulls a single image
+// before creating its container.
+func (da *DockerAgent) pullStackImages(ctx context.Context, services map[string]*stackService) error {
+	images := make(map[string]struct{})
+	for _, svc := range services {
+		images[svc.image] = struct{}{}
+	}
+
+	var wg sync.WaitGroup
+	errCh := make(chan error, len(images))
+
+	for image := range images {
+		wg.Add(1)
+		go func(image string) {
+			defer wg.Done()
+			reader, err := da.dockerClient.ImagePull(ctx, image, types.ImagePullOptions{})
+			if err != nil {
+				errCh <- fmt.Errorf("failed to pull %s: %v", image, err)
+				return
+			}
+			defer reader.Close()
+			io.Copy(io.Discard, reader)
+		}(image)
+	}
+
+	wg.Wait()
+	close(errCh)
+	for err := range errCh {
+		return err
+	}
+	return nil
+}
+
+// startStackService creates and starts one service's container, attached to
+// the stack's network and labeled so stackDown/stackPs/stackLogs can find it.
+func (da *DockerAgent) startStackService(ctx context.Context, stackName, networkName string, svc *stackService) (string, error) {
+	portBindings := make(nat.PortMap)
+	exposedPorts := make(nat.PortSet)
+	for containerPort, hostPort := range svc.ports {
+		port, err := nat.NewPort("tcp", containerPort)
+		if err != nil {
+			continue
+		}
+		exposedPorts[port] = struct{}{}
+		portBindings[port] = []nat.PortBinding{
+			{HostIP: "0.0.0.0", HostPort: fmt.Sprintf("%v", hostPort)},
+		}
+	}
+
+	containerConfig := &container.Config{
+		Image:        svc.image,
+		Env:          svc.env,
+		ExposedPorts: exposedPorts,
+		Healthcheck:  svc.healthcheck,
+		Labels: map[string]string{
+			stackLabel:        stackName,
+			stackServiceLabel: svc.name,
+		},
+	}
+
+	hostConfig := &container.HostConfig{
+		PortBindings: portBindings,
+		NetworkMode:  container.NetworkMode(networkName),
+		Binds:        svc.volumes,
+		RestartPolicy: container.RestartPolicy{
+			Name: svc.restartPolicy,
+		},
+		Resources: container.Resources{
+			Memory:   svc.memoryBytes,
+			NanoCPUs: svc.nanoCPUs,
+		},
+	}
+
+	containerName := fmt.Sprintf("%s-%s", stackName, svc.name)
+	resp, err := da.dockerClient.ContainerCreate(ctx, containerConfig, hostConfig, nil, nil, containerName)
+	if err != nil {
+		return "", fmt.Errorf("failed to create container: %v", err)
+	}
+
+	if err := da.dockerClient.ContainerStart(ctx, resp.ID, types.ContainerStartOptions{}); err != nil {
+		return "", fmt.Errorf("failed to start container: %v", err)
+	}
+
+	return resp.ID, nil
+}
+
+// waitForHealthy polls a container's inspect state until Docker reports it
+// healthy, or returns an error once stackHealthTimeout elapses.
+func (da *DockerAgent) waitForHealthy(ctx context.Context, containerID string) error {
+	deadline := time.Now().Add(stackHealthTimeout)
+	ticker := time.NewTicker(stackHealthPollInterval)
+	defer ticker.Stop()
+
+	for {
+		inspect, err := da.dockerClient.ContainerInspect(ctx, containerID)
+		if err == nil && inspect.State.Health != nil && inspect.State.Health.Status == "healthy" {
+			return nil
+		}
+
+		if time.Now().After(deadline) {
+			return fmt.Errorf("timed out waiting for healthcheck")
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+// stackNetworkName returns the dedicated bridge network name deployStack
+// creates for a given stack.
+func stackNetworkName(stackName string) string {
+	return fmt.Sprintf("sams-stack-%s", stackName)
+}
+
+// stackLabelFilter builds a filters.Args matching everything tagged with a
+// stack (and optionally a single service within it), for use by
+// stackDown/stackPs/stackLogs.
+func stackLabelFilter(stackName, service string) filters.Args {
+	args := filters.NewArgs()
+	args.Add("label", fmt.Sprintf("%s=%s", stackLabel, stackName))
+	if service != "" {
+		args.Add("label", fmt.Sprintf("%s=%s", stackServiceLabel, service))
+	}
+	return args
+}
+
+// stackDown tears down every resource deployStack created for a stack: its
+// containers, its dedicated network, and (if remove_volumes is set) its
+// named volumes.
+func (da *DockerAgent) stackDown(ctx context.Context, command map[string]interface{}) (map[string]interface{}, error) {
+	stackName, ok := command["stack"].(string)
+	if !ok || stackName == "" {
+		return nil, fmt.Errorf("stack is required")
+	}
+	removeVolumes, _ := command["remove_volumes"].(bool)
+
+	containers, err := da.dockerClient.ContainerList(ctx, types.ContainerListOptions{
+		All:     true,
+		Filters: stackLabelFilter(stackName, ""),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list stack containers: %v", err)
+	}
+
+	var removedContainers []string
+	for _, c := range containers {
+		timeoutSeconds := 10
+		da.dockerClient.ContainerStop(ctx, c.ID, container.StopOptions{Timeout: &timeoutSeconds})
+		if err := da.dockerClient.ContainerRemove(ctx, c.ID, types.ContainerRemoveOptions{Force: true}); err != nil {
+			return nil, fmt.Errorf("failed to remove container %s: %v", shortID(c.ID), err)
+		}
+		removedContainers = append(removedContainers, shortID(c.ID))
+	}
+
+	networkName := stackNetworkName(stackName)
+	networks, err := da.dockerClient.NetworkList(ctx, types.NetworkListOptions{Filters: stackLabelFilter(stackName, "")})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list stack network: %v", err)
+	}
+	for _, n := range networks {
+		if err := da.dockerClient.NetworkRemove(ctx, n.ID); err != nil {
+			return nil, fmt.Errorf("failed to remove network %s: %v", n.Name, err)
+		}
+	}
+
+	var removedVolumes []string
+	if removeVolumes {
+		volumes, err := da.dockerClient.VolumeList(ctx, volume.ListOptions{Filters: stackLabelFilter(stackName, "")})
+		if err != nil {
+			return nil, fmt.Errorf("failed to list stack volumes: %v", err)
+		}
+		for _, v := range volumes.Volumes {
+			if err := da.dockerClient.VolumeRemove(ctx, v.Name, true); err != nil {
+				return nil, fmt.Errorf("failed to remove volume %s: %v", v.Name, err)
+			}
+			removedVolumes = append(removedVolumes, v.Name)
+		}
+	}
+
+	return map[string]interface{}{
+		"stack":      stackName,
+		"network":    networkName,
+		"containers": removedContainers,
+		"volumes":    removedVolumes,
+		"action":     "stack_removed",
+	}, nil
+}
+
+// stackServiceStatus is one row of a stackPs result.
+type stackServiceStatus struct {
+	Service     string `json:"service"`
+	ContainerID string `json:"container_id"`
+	Name        string `json:"name"`
+	State       string `json:"state"`
+	Status      string `json:"status"`
+}
+
+// stackPs lists the containers belonging to a stack, the deployStack
+// equivalent of `docker compose ps`.
+func (da *DockerAgent) stackPs(ctx context.Context, command map[string]interface{}) (map[string]interface{}, error) {
+	stackName, ok := command["stack"].(string)
+	if !ok || stackName == "" {
+		return nil, fmt.Errorf("stack is required")
+	}
+
+	containers, err := da.dockerClient.ContainerList(ctx, types.ContainerListOptions{
+		All:     true,
+		Filters: stackLabelFilter(stackName, ""),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	services := make([]stackServiceStatus, 0, len(containers))
+	for _, c := range containers {
+		name := ""
+		if len(c.Names) > 0 {
+			name = c.Names[0]
+		}
+		services = append(services, stackServiceStatus{
+			Service:     c.Labels[stackServiceLabel],
+			ContainerID: shortID(c.ID),
+			Name:        name,
+			State:       c.State,
+			Status:      c.Status,
+		})
+	}
+
+	return map[string]interface{}{
+		"stack":    stackName,
+		"services": services,
+	}, nil
+}
+
+// stackLogs fetches logs for a stack's containers, either every service or
+// (when "service" is set) just the one matching service.
+func (da *DockerAgent) stackLogs(ctx context.Context, command map[string]interface{}) (map[string]interface{}, error) {
+	stackName, ok := command["stack"].(string)
+	if !ok || stackName == "" {
+		return nil, fmt.Errorf("stack is required")
+	}
+	service, _ := command["service"].(string)
+
+	tail := "100"
+	if t, ok := command["tail"].(string); ok && t != "" {
+		tail = t
+	}
+
+	containers, err := da.dockerClient.ContainerList(ctx, types.ContainerListOptions{
+		All:     true,
+		Filters: stackLabelFilter(stackName, service),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	logs := make(map[string]string, len(containers))
+	for _, c := range containers {
+		reader, err := da.dockerClient.ContainerLogs(ctx, c.ID, types.ContainerLogsOptions{
+			ShowStdout: true,
+			ShowStderr: true,
+			Tail:       tail,
+			Timestamps: true,
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to get logs for %s: %v", c.Labels[stackServiceLabel], err)
+		}
+		content, err := io.ReadAll(reader)
+		reader.Close()
+		if err != nil {
+			return nil, err
+		}
+		logs[c.Labels[stackServiceLabel]] = string(content)
+	}
+
+	return map[string]interface{}{
+		"stack": stackName,
+		"logs":  logs,
+	}, nil
+}
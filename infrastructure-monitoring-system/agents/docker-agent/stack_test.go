@@ -0,0 +1,208 @@
+package main
+
+import (
+	"context"
+	"testing"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/volume"
+)
+
+// Test that parseStackServices extracts every field a service spec can
+// carry, and rejects a depends_on that names a service not in the stack.
+func TestParseStackServices(t *testing.T) {
+	raw := map[string]interface{}{
+		"db": map[string]interface{}{
+			"image":       "postgres:15",
+			"environment": map[string]interface{}{"POSTGRES_PASSWORD": "secret"},
+			"volumes":     []interface{}{"dbdata:/var/lib/postgresql/data"},
+			"healthcheck": map[string]interface{}{
+				"test":     []interface{}{"CMD", "pg_isready"},
+				"interval": 5.0,
+				"retries":  5.0,
+			},
+		},
+		"web": map[string]interface{}{
+			"image":      "myapp:latest",
+			"depends_on": []interface{}{"db"},
+			"ports":      map[string]interface{}{"8080": "8080"},
+			"resources":  map[string]interface{}{"memory_mb": 256.0, "cpus": 0.5},
+		},
+	}
+
+	services, err := parseStackServices(raw)
+	if err != nil {
+		t.Fatalf("parseStackServices failed: %v", err)
+	}
+
+	db := services["db"]
+	if db.image != "postgres:15" {
+		t.Errorf("Expected db image 'postgres:15', got %q", db.image)
+	}
+	if len(db.volumes) != 1 || db.volumes[0] != "dbdata:/var/lib/postgresql/data" {
+		t.Errorf("Expected db volume bind, got %v", db.volumes)
+	}
+	if db.healthcheck == nil || db.healthcheck.Retries != 5 {
+		t.Errorf("Expected db healthcheck with 5 retries, got %v", db.healthcheck)
+	}
+
+	web := services["web"]
+	if len(web.dependsOn) != 1 || web.dependsOn[0] != "db" {
+		t.Errorf("Expected web to depend on db, got %v", web.dependsOn)
+	}
+	if web.memoryBytes != 256*1024*1024 {
+		t.Errorf("Expected web memory limit 256MB in bytes, got %d", web.memoryBytes)
+	}
+	if web.nanoCPUs != 500_000_000 {
+		t.Errorf("Expected web nanoCPUs 500000000, got %d", web.nanoCPUs)
+	}
+
+	if _, err := parseStackServices(map[string]interface{}{
+		"web": map[string]interface{}{"image": "myapp:latest", "depends_on": []interface{}{"missing"}},
+	}); err == nil {
+		t.Error("Expected an error for depends_on referencing an unknown service")
+	}
+}
+
+// Test that orderStackServices groups services into dependency levels and
+// detects a cycle.
+func TestOrderStackServices(t *testing.T) {
+	services := map[string]*stackService{
+		"db":    {name: "db"},
+		"cache": {name: "cache"},
+		"web":   {name: "web", dependsOn: []string{"db", "cache"}},
+	}
+
+	levels, err := orderStackServices(services)
+	if err != nil {
+		t.Fatalf("orderStackServices failed: %v", err)
+	}
+	if len(levels) != 2 {
+		t.Fatalf("Expected 2 levels, got %d", len(levels))
+	}
+	if len(levels[0]) != 2 || len(levels[1]) != 1 || levels[1][0] != "web" {
+		t.Errorf("Expected [db,cache] then [web], got %v", levels)
+	}
+
+	cyclic := map[string]*stackService{
+		"a": {name: "a", dependsOn: []string{"b"}},
+		"b": {name: "b", dependsOn: []string{"a"}},
+	}
+	if _, err := orderStackServices(cyclic); err == nil {
+		t.Error("Expected an error for a circular depends_on")
+	}
+}
+
+// Test that deployStack creates the stack's network, pulls images, and
+// starts services in dependency order.
+func TestDeployStack(t *testing.T) {
+	healthy := types.ContainerJSON{
+		ContainerJSONBase: &types.ContainerJSONBase{
+			State: &types.ContainerState{Health: &types.Health{Status: "healthy"}},
+		},
+	}
+	mockClient := &MockDockerClient{inspectResult: &healthy}
+	agent := &DockerAgent{config: &Config{}, dockerClient: mockClient}
+
+	command := map[string]interface{}{
+		"stack": "myapp",
+		"services": map[string]interface{}{
+			"db": map[string]interface{}{
+				"image": "postgres:15",
+				"healthcheck": map[string]interface{}{
+					"test": []interface{}{"CMD", "pg_isready"},
+				},
+			},
+			"web": map[string]interface{}{
+				"image":      "myapp:latest",
+				"depends_on": []interface{}{"db"},
+			},
+		},
+		"volumes": []interface{}{"dbdata"},
+	}
+
+	result, err := agent.deployStack(context.Background(), command)
+	if err != nil {
+		t.Fatalf("deployStack failed: %v", err)
+	}
+
+	if result["network"] != "sams-stack-myapp" {
+		t.Errorf("Expected network 'sams-stack-myapp', got %v", result["network"])
+	}
+
+	containers, _ := result["containers"].(map[string]string)
+	if len(containers) != 2 {
+		t.Errorf("Expected 2 containers started, got %d", len(containers))
+	}
+
+	if _, err := agent.deployStack(context.Background(), map[string]interface{}{}); err == nil {
+		t.Error("Expected an error when stack is missing")
+	}
+}
+
+// Test that stackDown removes the stack's containers and network.
+func TestStackDown(t *testing.T) {
+	mockClient := &MockDockerClient{
+		containers: []types.Container{{ID: "c1", Names: []string{"/myapp-web"}}},
+		networks:   []types.NetworkResource{{ID: "n1", Name: "sams-stack-myapp"}},
+		volumes:    []*volume.Volume{{Name: "dbdata"}},
+	}
+	agent := &DockerAgent{config: &Config{}, dockerClient: mockClient}
+
+	result, err := agent.stackDown(context.Background(), map[string]interface{}{
+		"stack":          "myapp",
+		"remove_volumes": true,
+	})
+	if err != nil {
+		t.Fatalf("stackDown failed: %v", err)
+	}
+
+	containers, _ := result["containers"].([]string)
+	if len(containers) != 1 {
+		t.Errorf("Expected 1 container removed, got %v", containers)
+	}
+	volumes, _ := result["volumes"].([]string)
+	if len(volumes) != 1 || volumes[0] != "dbdata" {
+		t.Errorf("Expected volume 'dbdata' removed, got %v", volumes)
+	}
+}
+
+// Test that stackPs reports each container's service label and state.
+func TestStackPs(t *testing.T) {
+	mockClient := &MockDockerClient{
+		containers: []types.Container{
+			{ID: "c1", Names: []string{"/myapp-web"}, State: "running", Status: "Up 2 minutes", Labels: map[string]string{stackServiceLabel: "web"}},
+		},
+	}
+	agent := &DockerAgent{config: &Config{}, dockerClient: mockClient}
+
+	result, err := agent.stackPs(context.Background(), map[string]interface{}{"stack": "myapp"})
+	if err != nil {
+		t.Fatalf("stackPs failed: %v", err)
+	}
+
+	services, _ := result["services"].([]stackServiceStatus)
+	if len(services) != 1 || services[0].Service != "web" || services[0].State != "running" {
+		t.Errorf("Expected service 'web' running, got %v", services)
+	}
+}
+
+// Test that stackLogs keys each container's logs by its service label.
+func TestStackLogs(t *testing.T) {
+	mockClient := &MockDockerClient{
+		containers: []types.Container{
+			{ID: "c1", Labels: map[string]string{stackServiceLabel: "web"}},
+		},
+	}
+	agent := &DockerAgent{config: &Config{}, dockerClient: mockClient}
+
+	result, err := agent.stackLogs(context.Background(), map[string]interface{}{"stack": "myapp"})
+	if err != nil {
+		t.Fatalf("stackLogs failed: %v", err)
+	}
+
+	logs, _ := result["logs"].(map[string]string)
+	if logs["web"] != "test logs" {
+		t.Errorf("Expected web logs 'test logs', got %q", logs["web"])
+	}
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"context"
+	"fmt"
+)
+
+// Transport selections for Config.Transport.
+const (
+	transportHTTP = "http"
+	transportGRPC = "grpc"
+	transportNATS = "nats"
+)
+
+// Transport abstracts how the agent exchanges data with the SAMS server, so
+// the original HTTP+JSON path can be swapped for gRPC or NATS without
+// touching call sites like sendToServer.
+//
+// Streaming command types (container_logs_follow, container_exec) still
+// require the dedicated command WebSocket regardless of Transport — see
+// runCommandsFromTransport. Subscribe only carries simple request/response
+// commands.
+type Transport interface {
+	// Send delivers a single payload to path, an endpoint name each
+	// transport maps onto its own addressing scheme (an HTTP path, a
+	// gRPC method, or a NATS subject).
+	Send(ctx context.Context, path string, payload interface{}) error
+
+	// Subscribe returns a channel of inbound commands pushed by the
+	// server for topic. The channel is closed when the subscription
+	// ends; callers should resubscribe (handleRemoteCommands already
+	// does, with backoff).
+	Subscribe(ctx context.Context, topic string) (<-chan map[string]interface{}, error)
+}
+
+// newTransport constructs the Transport selected by Config.Transport,
+// defaulting to HTTP+JSON when unset.
+func newTransport(da *DockerAgent) (Transport, error) {
+	switch da.config.Transport {
+	case "", transportHTTP:
+		return newHTTPTransport(da), nil
+	case transportGRPC:
+		return newGRPCTransport(da)
+	case transportNATS:
+		return newNATSTransport(da)
+	default:
+		return nil, fmt.Errorf("unknown transport %q", da.config.Transport)
+	}
+}
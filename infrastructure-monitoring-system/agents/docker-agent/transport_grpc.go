@@ -0,0 +1,114 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+)
+
+// grpcConn is the subset of *grpc.ClientConn that grpcTransport uses. It
+// exists so tests can supply a fake connection instead of dialing a real
+// gRPC server, the same way MockDockerClient stands in for the Docker SDK's
+// client.
+type grpcConn interface {
+	Invoke(ctx context.Context, method string, args, reply interface{}, opts ...grpc.CallOption) error
+	NewStream(ctx context.Context, desc *grpc.StreamDesc, method string, opts ...grpc.CallOption) (grpc.ClientStream, error)
+}
+
+// grpcTransport delivers metrics/alerts over a unary gRPC call per Send and
+// receives commands over a server-streaming RPC per Subscribe. Messages are
+// plain JSON-able maps rather than protobuf types, since this agent has no
+// protoc build step; see jsonCodec in transport_grpc_codec.go, registered
+// by that file's init.
+type grpcTransport struct {
+	da   *DockerAgent
+	conn grpcConn
+}
+
+// newGRPCTransport dials Config.GRPCServerAddr (falling back to ServerURL),
+// using the JSON codec so command/metrics payloads don't need a .proto
+// schema. The connection is secured with mTLS when Config.TLSCertFile/
+// TLSKeyFile/TLSCAFile are set, matching httpClient's transport; otherwise
+// it falls back to plain gRPC (envelopes are still signed/verified when
+// the agent's signing keys are configured).
+func newGRPCTransport(da *DockerAgent) (*grpcTransport, error) {
+	target := da.config.GRPCServerAddr
+	if target == "" {
+		target = da.config.ServerURL
+	}
+
+	tlsConfig, err := buildClientTLSConfig(da.config.TLSCertFile, da.config.TLSKeyFile, da.config.TLSCAFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to configure mTLS for gRPC: %v", err)
+	}
+
+	transportCreds := insecure.NewCredentials()
+	if tlsConfig != nil {
+		transportCreds = credentials.NewTLS(tlsConfig)
+	}
+
+	conn, err := grpc.NewClient(target,
+		grpc.WithTransportCredentials(transportCreds),
+		grpc.WithDefaultCallOptions(grpc.CallContentSubtype(jsonCodecName)))
+	if err != nil {
+		return nil, fmt.Errorf("failed to dial gRPC server %s: %v", target, err)
+	}
+
+	return &grpcTransport{da: da, conn: conn}, nil
+}
+
+// Send implements Transport, signing payload into an envelope the same way
+// httpTransport.Send does when the agent has a private key configured.
+func (t *grpcTransport) Send(ctx context.Context, method string, payload interface{}) error {
+	envelope, err := envelopeIfConfigured(t.da, payload)
+	if err != nil {
+		return err
+	}
+
+	var reply struct{}
+	if err := t.conn.Invoke(ctx, method, envelope, &reply); err != nil {
+		return fmt.Errorf("grpc send to %s failed: %v", method, err)
+	}
+	return nil
+}
+
+// Subscribe implements Transport, opening a server-streaming RPC that the
+// SAMS server pushes one command per frame over. Each frame is verified
+// through da.verifyInboundCommand before it reaches the channel, the same
+// as httpTransport.Subscribe.
+func (t *grpcTransport) Subscribe(ctx context.Context, method string) (<-chan map[string]interface{}, error) {
+	stream, err := t.conn.NewStream(ctx, &grpc.StreamDesc{ServerStreams: true}, method)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open gRPC command stream: %v", err)
+	}
+
+	ch := make(chan map[string]interface{})
+	go func() {
+		defer close(ch)
+		for {
+			var raw map[string]interface{}
+			if err := stream.RecvMsg(&raw); err != nil {
+				if ctx.Err() == nil {
+					log.Printf("gRPC command stream ended: %v", err)
+				}
+				return
+			}
+			command, err := t.da.verifyInboundCommand(raw)
+			if err != nil {
+				log.Printf("Rejected command envelope: %v", err)
+				continue
+			}
+			select {
+			case ch <- command:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	return ch, nil
+}
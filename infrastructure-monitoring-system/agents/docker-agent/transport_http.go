@@ -0,0 +1,106 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/gorilla/websocket"
+)
+
+// httpTransport is the agent's original transport: POSTing JSON (signed
+// when an agent key is configured) to the server's REST endpoints for Send,
+// and a dedicated command WebSocket for Subscribe.
+type httpTransport struct {
+	da *DockerAgent
+}
+
+func newHTTPTransport(da *DockerAgent) *httpTransport {
+	return &httpTransport{da: da}
+}
+
+// Send implements Transport.
+func (t *httpTransport) Send(ctx context.Context, path string, payload interface{}) error {
+	jsonData, err := json.Marshal(payload)
+	if err != nil {
+		return fmt.Errorf("failed to marshal data: %v", err)
+	}
+
+	if t.da.agentPrivKey != nil {
+		jsonData, err = json.Marshal(signEnvelope(t.da.agentPrivKey, jsonData))
+		if err != nil {
+			return fmt.Errorf("failed to sign envelope: %v", err)
+		}
+	}
+
+	url := t.da.config.ServerURL + path
+	req, err := http.NewRequestWithContext(ctx, "POST", url, bytes.NewBuffer(jsonData))
+	if err != nil {
+		return fmt.Errorf("failed to create request: %v", err)
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+	if t.da.config.APIKey != "" {
+		req.Header.Set("Authorization", "Bearer "+t.da.config.APIKey)
+	}
+
+	resp, err := t.da.httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to send request: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("server returned status %d", resp.StatusCode)
+	}
+
+	log.Printf("Successfully sent data to %s", path)
+	return nil
+}
+
+// Subscribe dials a command WebSocket at topic and returns a channel of
+// verified, decoded commands. It's used by runCommandsFromTransport; the
+// primary command channel (runCommandChannel) dials directly instead,
+// since it also needs the raw *websocket.Conn to stream log-follow/exec
+// frames back.
+func (t *httpTransport) Subscribe(ctx context.Context, topic string) (<-chan map[string]interface{}, error) {
+	wsURL := strings.Replace(t.da.config.ServerURL, "http", "ws", 1) + topic
+
+	header := http.Header{}
+	if t.da.config.APIKey != "" {
+		header.Set("Authorization", "Bearer "+t.da.config.APIKey)
+	}
+
+	conn, _, err := websocket.DefaultDialer.DialContext(ctx, wsURL, header)
+	if err != nil {
+		return nil, fmt.Errorf("failed to dial command channel: %v", err)
+	}
+
+	ch := make(chan map[string]interface{})
+	go func() {
+		defer close(ch)
+		defer conn.Close()
+		for {
+			var raw map[string]interface{}
+			if err := conn.ReadJSON(&raw); err != nil {
+				return
+			}
+			command, err := t.da.verifyInboundCommand(raw)
+			if err != nil {
+				log.Printf("Rejected command envelope: %v", err)
+				continue
+			}
+			select {
+			case ch <- command:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	return ch, nil
+}
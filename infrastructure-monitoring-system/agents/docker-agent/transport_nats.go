@@ -0,0 +1,104 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+
+	"github.com/nats-io/nats.go"
+)
+
+// natsConn is the subset of *nats.Conn that natsTransport uses, so tests
+// can supply an in-memory fake instead of dialing a real NATS server.
+type natsConn interface {
+	Publish(subject string, data []byte) error
+	Subscribe(subject string, cb nats.MsgHandler) (*nats.Subscription, error)
+}
+
+// natsTransport publishes metrics/alerts to a subject per Send and
+// subscribes to a commands subject per Subscribe, giving the server
+// fan-in/fan-out across many agents without per-agent HTTP connections.
+type natsTransport struct {
+	da   *DockerAgent
+	conn natsConn
+}
+
+// newNATSTransport connects to Config.NATSServerURL.
+func newNATSTransport(da *DockerAgent) (*natsTransport, error) {
+	conn, err := nats.Connect(da.config.NATSServerURL)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to NATS server %s: %v", da.config.NATSServerURL, err)
+	}
+	return &natsTransport{da: da, conn: conn}, nil
+}
+
+// natsSubject turns an HTTP-style path ("/api/v1/metrics") into a NATS
+// subject ("api.v1.metrics"), since subjects can't contain slashes.
+func natsSubject(path string) string {
+	subject := make([]byte, 0, len(path))
+	for i := 0; i < len(path); i++ {
+		c := path[i]
+		if c == '/' {
+			if i == 0 {
+				continue
+			}
+			c = '.'
+		}
+		subject = append(subject, c)
+	}
+	return string(subject)
+}
+
+// Send implements Transport, signing payload into an envelope the same way
+// httpTransport.Send does when the agent has a private key configured.
+func (t *natsTransport) Send(ctx context.Context, path string, payload interface{}) error {
+	envelope, err := envelopeIfConfigured(t.da, payload)
+	if err != nil {
+		return err
+	}
+
+	data, err := json.Marshal(envelope)
+	if err != nil {
+		return fmt.Errorf("failed to marshal data: %v", err)
+	}
+
+	if err := t.conn.Publish(natsSubject(path), data); err != nil {
+		return fmt.Errorf("failed to publish to %s: %v", path, err)
+	}
+	return nil
+}
+
+// Subscribe implements Transport. Each message is verified through
+// da.verifyInboundCommand before it reaches the channel, the same as
+// httpTransport.Subscribe.
+func (t *natsTransport) Subscribe(ctx context.Context, topic string) (<-chan map[string]interface{}, error) {
+	ch := make(chan map[string]interface{})
+
+	sub, err := t.conn.Subscribe(natsSubject(topic), func(msg *nats.Msg) {
+		var raw map[string]interface{}
+		if err := json.Unmarshal(msg.Data, &raw); err != nil {
+			return
+		}
+		command, err := t.da.verifyInboundCommand(raw)
+		if err != nil {
+			log.Printf("Rejected command envelope: %v", err)
+			return
+		}
+		select {
+		case ch <- command:
+		case <-ctx.Done():
+		}
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to subscribe to %s: %v", topic, err)
+	}
+
+	go func() {
+		<-ctx.Done()
+		sub.Unsubscribe()
+		close(ch)
+	}()
+
+	return ch, nil
+}
@@ -0,0 +1,296 @@
+package main
+
+import (
+	"context"
+	"crypto/ed25519"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"google.golang.org/grpc"
+)
+
+// fakeGRPCConn is an in-process double for grpcConn, standing in for a real
+// gRPC server the same way MockDockerClient stands in for the Docker SDK.
+type fakeGRPCConn struct {
+	invokedMethod string
+	invokedArgs   interface{}
+	commands      chan map[string]interface{}
+}
+
+func (f *fakeGRPCConn) Invoke(ctx context.Context, method string, args, reply interface{}, opts ...grpc.CallOption) error {
+	f.invokedMethod = method
+	f.invokedArgs = args
+	return nil
+}
+
+func (f *fakeGRPCConn) NewStream(ctx context.Context, desc *grpc.StreamDesc, method string, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+	return &fakeGRPCStream{ch: f.commands}, nil
+}
+
+// fakeGRPCStream implements grpc.ClientStream, overriding only RecvMsg
+// (the one method grpcTransport.Subscribe calls).
+type fakeGRPCStream struct {
+	grpc.ClientStream
+	ch chan map[string]interface{}
+}
+
+func (s *fakeGRPCStream) RecvMsg(m interface{}) error {
+	command, ok := <-s.ch
+	if !ok {
+		return io.EOF
+	}
+	ptr := m.(*map[string]interface{})
+	*ptr = command
+	return nil
+}
+
+// fakeNATSConn is an in-process double for natsConn, standing in for an
+// embedded NATS server.
+type fakeNATSConn struct {
+	publishedSubject string
+	publishedData    []byte
+	subscribeHandler nats.MsgHandler
+}
+
+func (f *fakeNATSConn) Publish(subject string, data []byte) error {
+	f.publishedSubject = subject
+	f.publishedData = data
+	return nil
+}
+
+func (f *fakeNATSConn) Subscribe(subject string, cb nats.MsgHandler) (*nats.Subscription, error) {
+	f.subscribeHandler = cb
+	return &nats.Subscription{}, nil
+}
+
+// TestTransportSend runs the same send-and-verify assertions against all
+// three Transport implementations: HTTP via httptest, gRPC via a fake
+// grpcConn, and NATS via a fake natsConn.
+func TestTransportSend(t *testing.T) {
+	payload := map[string]interface{}{"key": "value"}
+
+	t.Run("http", func(t *testing.T) {
+		var gotPath string
+		var gotBody map[string]interface{}
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			gotPath = r.URL.Path
+			json.NewDecoder(r.Body).Decode(&gotBody)
+			w.WriteHeader(http.StatusOK)
+		}))
+		defer server.Close()
+
+		transport := newHTTPTransport(&DockerAgent{
+			config:     &Config{ServerURL: server.URL},
+			httpClient: &http.Client{},
+		})
+
+		if err := transport.Send(context.Background(), "/api/test", payload); err != nil {
+			t.Fatalf("Send failed: %v", err)
+		}
+		if gotPath != "/api/test" {
+			t.Errorf("Expected path /api/test, got %q", gotPath)
+		}
+		if gotBody["key"] != "value" {
+			t.Errorf("Unexpected request body: %v", gotBody)
+		}
+	})
+
+	t.Run("grpc", func(t *testing.T) {
+		fake := &fakeGRPCConn{}
+		transport := &grpcTransport{da: &DockerAgent{config: &Config{}}, conn: fake}
+
+		if err := transport.Send(context.Background(), "/api/test", payload); err != nil {
+			t.Fatalf("Send failed: %v", err)
+		}
+		if fake.invokedMethod != "/api/test" {
+			t.Errorf("Expected method /api/test, got %q", fake.invokedMethod)
+		}
+		if args, ok := fake.invokedArgs.(map[string]interface{}); !ok || args["key"] != "value" {
+			t.Errorf("Unexpected invoked args: %v", fake.invokedArgs)
+		}
+	})
+
+	t.Run("nats", func(t *testing.T) {
+		fake := &fakeNATSConn{}
+		transport := &natsTransport{da: &DockerAgent{config: &Config{}}, conn: fake}
+
+		if err := transport.Send(context.Background(), "/api/test", payload); err != nil {
+			t.Fatalf("Send failed: %v", err)
+		}
+		if fake.publishedSubject != "api.test" {
+			t.Errorf("Expected subject api.test, got %q", fake.publishedSubject)
+		}
+		var decoded map[string]interface{}
+		if err := json.Unmarshal(fake.publishedData, &decoded); err != nil {
+			t.Fatalf("Failed to decode published payload: %v", err)
+		}
+		if decoded["key"] != "value" {
+			t.Errorf("Unexpected published payload: %v", decoded)
+		}
+	})
+}
+
+// TestTransportSendSignsWhenConfigured asserts that, like httpTransport,
+// the gRPC and NATS transports wrap Send's payload in a signed
+// commandEnvelope when the agent has an agentPrivKey configured, instead
+// of handing the plain payload to the wire.
+func TestTransportSendSignsWhenConfigured(t *testing.T) {
+	_, priv := newEnvelopeKeyPair(t)
+	payload := map[string]interface{}{"key": "value"}
+
+	t.Run("grpc", func(t *testing.T) {
+		fake := &fakeGRPCConn{}
+		transport := &grpcTransport{da: &DockerAgent{config: &Config{}, agentPrivKey: priv}, conn: fake}
+
+		if err := transport.Send(context.Background(), "/api/test", payload); err != nil {
+			t.Fatalf("Send failed: %v", err)
+		}
+		envelope, ok := fake.invokedArgs.(commandEnvelope)
+		if !ok {
+			t.Fatalf("Expected invoked args to be a signed commandEnvelope, got %T", fake.invokedArgs)
+		}
+		if envelope.Signature == "" {
+			t.Error("Expected a non-empty signature")
+		}
+	})
+
+	t.Run("nats", func(t *testing.T) {
+		fake := &fakeNATSConn{}
+		transport := &natsTransport{da: &DockerAgent{config: &Config{}, agentPrivKey: priv}, conn: fake}
+
+		if err := transport.Send(context.Background(), "/api/test", payload); err != nil {
+			t.Fatalf("Send failed: %v", err)
+		}
+		var envelope commandEnvelope
+		if err := json.Unmarshal(fake.publishedData, &envelope); err != nil {
+			t.Fatalf("Failed to decode published envelope: %v", err)
+		}
+		if envelope.Signature == "" {
+			t.Error("Expected a non-empty signature")
+		}
+	})
+}
+
+// TestTransportSubscribe runs the same subscribe-and-receive assertions
+// against the gRPC and NATS transports (the HTTP transport's Subscribe
+// dials a real WebSocket and is not exercised here).
+func TestTransportSubscribe(t *testing.T) {
+	command := map[string]interface{}{"type": "container_start", "container_id": "c1"}
+
+	t.Run("grpc", func(t *testing.T) {
+		fake := &fakeGRPCConn{commands: make(chan map[string]interface{}, 1)}
+		transport := &grpcTransport{da: &DockerAgent{config: &Config{}}, conn: fake}
+
+		ch, err := transport.Subscribe(context.Background(), "/commands")
+		if err != nil {
+			t.Fatalf("Subscribe failed: %v", err)
+		}
+
+		fake.commands <- command
+		got := <-ch
+		if got["container_id"] != "c1" {
+			t.Errorf("Unexpected command received: %v", got)
+		}
+	})
+
+	t.Run("nats", func(t *testing.T) {
+		fake := &fakeNATSConn{}
+		transport := &natsTransport{da: &DockerAgent{config: &Config{}}, conn: fake}
+
+		ch, err := transport.Subscribe(context.Background(), "/commands")
+		if err != nil {
+			t.Fatalf("Subscribe failed: %v", err)
+		}
+		if fake.subscribeHandler == nil {
+			t.Fatal("Expected Subscribe to register a handler")
+		}
+
+		data, _ := json.Marshal(command)
+		go fake.subscribeHandler(&nats.Msg{Data: data})
+
+		got := <-ch
+		if got["container_id"] != "c1" {
+			t.Errorf("Unexpected command received: %v", got)
+		}
+	})
+}
+
+// TestTransportSubscribeRejectsUnverifiedCommand asserts that the gRPC and
+// NATS transports drop an inbound command that fails verifyInboundCommand
+// (e.g. unsigned, when the agent has a server public key configured)
+// instead of delivering it to the channel, matching httpTransport.Subscribe.
+func TestTransportSubscribeRejectsUnverifiedCommand(t *testing.T) {
+	pub, _ := newEnvelopeKeyPair(t)
+	command := map[string]interface{}{"type": "container_start", "container_id": "c1"}
+
+	t.Run("grpc", func(t *testing.T) {
+		fake := &fakeGRPCConn{commands: make(chan map[string]interface{}, 2)}
+		da := &DockerAgent{config: &Config{}, serverPubKey: pub, commandSkew: 30 * time.Second, seenNonces: newNonceCache(16)}
+		transport := &grpcTransport{da: da, conn: fake}
+
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		ch, err := transport.Subscribe(ctx, "/commands")
+		if err != nil {
+			t.Fatalf("Subscribe failed: %v", err)
+		}
+
+		fake.commands <- command
+		signed, _ := json.Marshal(signEnvelope(mustGenerateKey(t), mustMarshal(t, command)))
+		var envelopeMap map[string]interface{}
+		json.Unmarshal(signed, &envelopeMap)
+		fake.commands <- envelopeMap
+
+		select {
+		case got := <-ch:
+			t.Errorf("Expected unverified commands to be dropped, got: %v", got)
+		case <-time.After(50 * time.Millisecond):
+		}
+	})
+
+	t.Run("nats", func(t *testing.T) {
+		fake := &fakeNATSConn{}
+		da := &DockerAgent{config: &Config{}, serverPubKey: pub, commandSkew: 30 * time.Second, seenNonces: newNonceCache(16)}
+		transport := &natsTransport{da: da, conn: fake}
+
+		ch, err := transport.Subscribe(context.Background(), "/commands")
+		if err != nil {
+			t.Fatalf("Subscribe failed: %v", err)
+		}
+
+		data, _ := json.Marshal(command)
+		go fake.subscribeHandler(&nats.Msg{Data: data})
+
+		select {
+		case got := <-ch:
+			t.Errorf("Expected unverified command to be dropped, got: %v", got)
+		case <-time.After(50 * time.Millisecond):
+		}
+	})
+}
+
+// mustGenerateKey returns a fresh Ed25519 private key unrelated to the
+// agent's configured server key, standing in for an attacker signing with
+// the wrong key.
+func mustGenerateKey(t *testing.T) ed25519.PrivateKey {
+	t.Helper()
+	_, priv, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatalf("failed to generate key: %v", err)
+	}
+	return priv
+}
+
+func mustMarshal(t *testing.T, v interface{}) []byte {
+	t.Helper()
+	data, err := json.Marshal(v)
+	if err != nil {
+		t.Fatalf("failed to marshal: %v", err)
+	}
+	return data
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"context"
+	"crypto/ed25519"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// imageSignature is the detached signature format expected at
+// Config.ImageSignatureSource: an Ed25519 signature over an image digest,
+// plus the ID of the trusted key that produced it.
+type imageSignature struct {
+	KeyID     string `json:"key_id"`
+	Signature string `json:"signature"`
+}
+
+// imageTrustResult reports whether a deployed image's digest was verified
+// against Config.TrustedImageSigningKeys, so the SAMS server can surface
+// unsigned or untrusted deployments in the command response rather than
+// assuming every deploy was vetted.
+type imageTrustResult struct {
+	Digest   string `json:"digest"`
+	Verified bool   `json:"verified"`
+	KeyID    string `json:"key_id,omitempty"`
+}
+
+// verifyImageTrust checks imageName's digest against a detached signature
+// fetched from Config.ImageSignatureSource and verified against
+// Config.TrustedImageSigningKeys. It returns (nil, nil) when no trusted
+// keys are configured, so content-trust verification stays opt-in; a
+// non-nil error means the caller must refuse to run the image.
+func (da *DockerAgent) verifyImageTrust(ctx context.Context, imageName string) (*imageTrustResult, error) {
+	if len(da.config.TrustedImageSigningKeys) == 0 {
+		return nil, nil
+	}
+
+	digest, err := da.imageDigest(ctx, imageName)
+	if err != nil {
+		return nil, fmt.Errorf("failed to resolve image digest: %v", err)
+	}
+
+	sig, err := fetchImageSignature(ctx, da.config.ImageSignatureSource, digest)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch signature for %s: %v", digest, err)
+	}
+
+	pubKeyHex, ok := da.config.TrustedImageSigningKeys[sig.KeyID]
+	if !ok {
+		return nil, fmt.Errorf("signature references unknown key_id %q", sig.KeyID)
+	}
+
+	pubKeyRaw, err := hex.DecodeString(pubKeyHex)
+	if err != nil || len(pubKeyRaw) != ed25519.PublicKeySize {
+		return nil, fmt.Errorf("invalid trusted key %q in config", sig.KeyID)
+	}
+
+	signature, err := hex.DecodeString(sig.Signature)
+	if err != nil {
+		return nil, fmt.Errorf("malformed signature encoding: %v", err)
+	}
+
+	if !ed25519.Verify(ed25519.PublicKey(pubKeyRaw), []byte(digest), signature) {
+		return nil, fmt.Errorf("signature does not verify against key %q", sig.KeyID)
+	}
+
+	return &imageTrustResult{Digest: digest, Verified: true, KeyID: sig.KeyID}, nil
+}
+
+// imageDigest resolves imageName's content digest via ImageInspectWithRaw,
+// preferring a repo digest (registry-assigned, stable across retags) and
+// falling back to the local image ID.
+func (da *DockerAgent) imageDigest(ctx context.Context, imageName string) (string, error) {
+	inspect, _, err := da.dockerClient.ImageInspectWithRaw(ctx, imageName)
+	if err != nil {
+		return "", err
+	}
+	if len(inspect.RepoDigests) > 0 {
+		if _, digest, ok := strings.Cut(inspect.RepoDigests[0], "@"); ok {
+			return digest, nil
+		}
+		return inspect.RepoDigests[0], nil
+	}
+	if inspect.ID == "" {
+		return "", fmt.Errorf("image has no digest or ID")
+	}
+	return inspect.ID, nil
+}
+
+// fetchImageSignature retrieves the detached signature for digest from
+// source, which is either an "http(s)://" transparency endpoint queried as
+// "<source>/<digest>.sig", or a local directory holding "<digest>.sig"
+// files (with "/" in digest replaced by "_" for a valid filename).
+func fetchImageSignature(ctx context.Context, source, digest string) (*imageSignature, error) {
+	if source == "" {
+		return nil, fmt.Errorf("image_signature_source is not configured")
+	}
+
+	filename := strings.ReplaceAll(digest, "/", "_") + ".sig"
+
+	var raw []byte
+	if strings.HasPrefix(source, "http://") || strings.HasPrefix(source, "https://") {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, strings.TrimRight(source, "/")+"/"+filename, nil)
+		if err != nil {
+			return nil, err
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			return nil, err
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			return nil, fmt.Errorf("signature source returned status %d", resp.StatusCode)
+		}
+		raw, err = io.ReadAll(resp.Body)
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		var err error
+		raw, err = os.ReadFile(filepath.Join(source, filename))
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	var sig imageSignature
+	if err := json.Unmarshal(raw, &sig); err != nil {
+		return nil, fmt.Errorf("malformed signature file: %v", err)
+	}
+	return &sig, nil
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/docker/docker/api/types"
+)
+
+// writeTestSignature writes a signed detached signature for digest into a
+// fresh temp directory and returns the directory path (for
+// Config.ImageSignatureSource).
+func writeTestSignature(t *testing.T, digest, keyID string, priv ed25519.PrivateKey) string {
+	t.Helper()
+	dir := t.TempDir()
+	sig := imageSignature{
+		KeyID:     keyID,
+		Signature: hex.EncodeToString(ed25519.Sign(priv, []byte(digest))),
+	}
+	raw, _ := json.Marshal(sig)
+	filename := filepath.Join(dir, digest+".sig")
+	if err := os.WriteFile(filename, raw, 0o644); err != nil {
+		t.Fatalf("failed to write test signature: %v", err)
+	}
+	return dir
+}
+
+// Test that verifyImageTrust is a no-op (returns nil, nil) when no trusted
+// keys are configured, so content-trust stays opt-in.
+func TestVerifyImageTrustDisabledWhenNoKeysConfigured(t *testing.T) {
+	agent := &DockerAgent{config: &Config{}, dockerClient: &MockDockerClient{}}
+
+	result, err := agent.verifyImageTrust(context.Background(), "nginx:latest")
+	if err != nil || result != nil {
+		t.Errorf("Expected no-op verification, got result=%v err=%v", result, err)
+	}
+}
+
+// Test that a correctly signed digest verifies against a trusted key.
+func TestVerifyImageTrustVerifiesSignedDigest(t *testing.T) {
+	pub, priv, _ := ed25519.GenerateKey(rand.Reader)
+	const digest = "sha256:deadbeef"
+	sourceDir := writeTestSignature(t, digest, "release-key", priv)
+
+	agent := &DockerAgent{
+		config: &Config{
+			TrustedImageSigningKeys: map[string]string{"release-key": hex.EncodeToString(pub)},
+			ImageSignatureSource:    sourceDir,
+		},
+		dockerClient: &MockDockerClient{
+			imageInspectResult: types.ImageInspect{RepoDigests: []string{"nginx@" + digest}},
+		},
+	}
+
+	result, err := agent.verifyImageTrust(context.Background(), "nginx:latest")
+	if err != nil {
+		t.Fatalf("Expected verification to succeed, got: %v", err)
+	}
+	if !result.Verified || result.Digest != digest || result.KeyID != "release-key" {
+		t.Errorf("Unexpected trust result: %+v", result)
+	}
+}
+
+// Test that a signature referencing a key ID absent from
+// TrustedImageSigningKeys is rejected.
+func TestVerifyImageTrustRejectsUnknownKey(t *testing.T) {
+	_, priv, _ := ed25519.GenerateKey(rand.Reader)
+	const digest = "sha256:deadbeef"
+	sourceDir := writeTestSignature(t, digest, "rogue-key", priv)
+
+	agent := &DockerAgent{
+		config: &Config{
+			TrustedImageSigningKeys: map[string]string{"release-key": "aa"},
+			ImageSignatureSource:    sourceDir,
+		},
+		dockerClient: &MockDockerClient{
+			imageInspectResult: types.ImageInspect{RepoDigests: []string{"nginx@" + digest}},
+		},
+	}
+
+	if _, err := agent.verifyImageTrust(context.Background(), "nginx:latest"); err == nil {
+		t.Error("Expected verification to fail for an unrecognized key_id")
+	}
+}
+
+// Test that deployContainer refuses to start an image whose digest isn't
+// signed by a trusted key, instead of silently deploying it.
+func TestDeployContainerRefusesUntrustedImage(t *testing.T) {
+	agent := &DockerAgent{
+		config: &Config{
+			TrustedImageSigningKeys: map[string]string{"release-key": "aa"},
+			ImageSignatureSource:    t.TempDir(),
+		},
+		dockerClient: &MockDockerClient{
+			imageInspectResult: types.ImageInspect{RepoDigests: []string{"nginx@sha256:deadbeef"}},
+		},
+	}
+
+	_, err := agent.deployContainer(context.Background(), map[string]interface{}{"image": "nginx:latest"})
+	if err == nil {
+		t.Error("Expected deployContainer to refuse an image with no signature available")
+	}
+}
+
+// Test that imageDigest prefers a repo digest over the local image ID.
+func TestImageDigestPrefersRepoDigest(t *testing.T) {
+	agent := &DockerAgent{
+		dockerClient: &MockDockerClient{
+			imageInspectResult: types.ImageInspect{
+				ID:          "sha256:localid",
+				RepoDigests: []string{"nginx@sha256:remotedigest"},
+			},
+		},
+	}
+
+	digest, err := agent.imageDigest(context.Background(), "nginx:latest")
+	if err != nil {
+		t.Fatalf("Expected imageDigest to succeed, got: %v", err)
+	}
+	if digest != "sha256:remotedigest" {
+		t.Errorf("Expected the repo digest to be preferred, got %q", digest)
+	}
+}
@@ -0,0 +1,248 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/pkg/stdcopy"
+	"github.com/gorilla/websocket"
+)
+
+// wsUpgrader upgrades the agent's own HTTP server (the same one serving
+// /metrics) to WebSocket for the streaming endpoints below. These serve a
+// directly-attached UI rather than the SAMS command channel, so frames are
+// written straight to the caller's connection instead of going through
+// writeStreamFrame/reportPullProgress.
+var wsUpgrader = websocket.Upgrader{
+	ReadBufferSize:  4096,
+	WriteBufferSize: 4096,
+}
+
+// watchForClientClose reads from conn until it sees a close/cancel text
+// message or the connection errors out, then calls cancel. It's the
+// WebSocket-server counterpart of a client hanging up mid-request: the
+// caller can stop following logs or a pull without waiting for it to finish.
+func watchForClientClose(conn *websocket.Conn, cancel context.CancelFunc) {
+	defer cancel()
+	for {
+		messageType, data, err := conn.ReadMessage()
+		if err != nil {
+			return
+		}
+		if messageType == websocket.CloseMessage {
+			return
+		}
+		switch strings.ToLower(strings.TrimSpace(string(data))) {
+		case "close", "cancel":
+			return
+		}
+	}
+}
+
+// agentContext returns da.ctx, or context.Background() if the agent hasn't
+// been started yet (e.g. under test), so handlers never derive from a nil
+// context.
+func (da *DockerAgent) agentContext() context.Context {
+	if da.ctx != nil {
+		return da.ctx
+	}
+	return context.Background()
+}
+
+// handleLogsWebSocket serves /ws/logs/{container_id}, streaming
+// ContainerLogs with Follow:true frame-by-frame until the client
+// disconnects, sends a close/cancel message, or the agent shuts down.
+// Query params: since, until, tail, timestamps.
+func (da *DockerAgent) handleLogsWebSocket(w http.ResponseWriter, r *http.Request) {
+	containerID := strings.TrimPrefix(r.URL.Path, "/ws/logs/")
+	if containerID == "" {
+		http.Error(w, "container_id is required", http.StatusBadRequest)
+		return
+	}
+
+	release, err := da.acquireStream(r.Context())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+	defer release()
+
+	conn, err := wsUpgrader.Upgrade(w, r, nil)
+	if err != nil {
+		log.Printf("Failed to upgrade logs WebSocket for %s: %v", containerID, err)
+		return
+	}
+	defer conn.Close()
+
+	query := r.URL.Query()
+	tail := query.Get("tail")
+	if tail == "" {
+		tail = "100"
+	}
+	timestamps := true
+	if t, err := strconv.ParseBool(query.Get("timestamps")); err == nil {
+		timestamps = t
+	}
+
+	ctx, cancel := context.WithCancel(da.agentContext())
+	defer cancel()
+	go watchForClientClose(conn, cancel)
+
+	reader, err := da.dockerClient.ContainerLogs(ctx, containerID, types.ContainerLogsOptions{
+		ShowStdout: true,
+		ShowStderr: true,
+		Follow:     true,
+		Tail:       tail,
+		Since:      query.Get("since"),
+		Until:      query.Get("until"),
+		Timestamps: timestamps,
+	})
+	if err != nil {
+		conn.WriteJSON(map[string]interface{}{"error": err.Error(), "done": true})
+		return
+	}
+	defer reader.Close()
+
+	stdoutR, stdoutW := io.Pipe()
+	stderrR, stderrW := io.Pipe()
+	go func() {
+		_, err := stdcopy.StdCopy(stdoutW, stderrW, reader)
+		stdoutW.CloseWithError(err)
+		stderrW.CloseWithError(err)
+	}()
+
+	var seq int64
+	var writeMu sync.Mutex
+	done := make(chan struct{})
+	forward := func(streamName string, r io.Reader) {
+		buf := make([]byte, 4096)
+		for {
+			n, err := r.Read(buf)
+			if n > 0 {
+				writeMu.Lock()
+				frameSeq := atomic.AddInt64(&seq, 1)
+				conn.WriteJSON(map[string]interface{}{
+					"seq":    frameSeq,
+					"stream": streamName,
+					"data":   string(buf[:n]),
+				})
+				writeMu.Unlock()
+			}
+			if err != nil {
+				return
+			}
+		}
+	}
+
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() { defer wg.Done(); forward("stdout", stdoutR) }()
+	go func() { defer wg.Done(); forward("stderr", stderrR) }()
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-ctx.Done():
+	case <-done:
+	}
+	writeMu.Lock()
+	conn.WriteJSON(map[string]interface{}{"done": true})
+	writeMu.Unlock()
+}
+
+// handlePullWebSocket serves /ws/pull, streaming an image pull's JSON
+// progress messages (status, id, progressDetail) as they arrive instead of
+// discarding them, until the pull finishes, errors, or the client sends a
+// close/cancel message. Query params: image (required), credential_id
+// (optional, looked up in Config.CredentialStore).
+func (da *DockerAgent) handlePullWebSocket(w http.ResponseWriter, r *http.Request) {
+	imageName := r.URL.Query().Get("image")
+	if imageName == "" {
+		http.Error(w, "image is required", http.StatusBadRequest)
+		return
+	}
+
+	registryAuth, err := da.resolveRegistryAuth(queryRegistryAuthCommand(r))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	conn, err := wsUpgrader.Upgrade(w, r, nil)
+	if err != nil {
+		log.Printf("Failed to upgrade pull WebSocket for %s: %v", imageName, err)
+		return
+	}
+	defer conn.Close()
+
+	ctx, cancel := context.WithCancel(da.agentContext())
+	defer cancel()
+	go watchForClientClose(conn, cancel)
+
+	if err := da.streamImagePullOverWS(ctx, conn, imageName, registryAuth); err != nil {
+		conn.WriteJSON(map[string]interface{}{"image": imageName, "error": err.Error(), "done": true})
+		return
+	}
+	conn.WriteJSON(map[string]interface{}{"image": imageName, "done": true})
+}
+
+// queryRegistryAuthCommand adapts a /ws/pull request's credential_id query
+// param into the map shape resolveRegistryAuth expects.
+func queryRegistryAuthCommand(r *http.Request) map[string]interface{} {
+	credID := r.URL.Query().Get("credential_id")
+	if credID == "" {
+		return map[string]interface{}{}
+	}
+	return map[string]interface{}{
+		"registry_auth": map[string]interface{}{"credential_id": credID},
+	}
+}
+
+// streamImagePullOverWS pulls imageName and writes each JSON progress
+// message Docker emits straight to conn, rather than routing it through
+// reportPullProgress like runImagePull does for server-dispatched pulls.
+func (da *DockerAgent) streamImagePullOverWS(ctx context.Context, conn *websocket.Conn, imageName, registryAuth string) error {
+	reader, err := da.dockerClient.ImagePull(ctx, imageName, types.ImagePullOptions{RegistryAuth: registryAuth})
+	if err != nil {
+		return err
+	}
+	defer reader.Close()
+
+	decoder := json.NewDecoder(reader)
+	for {
+		var progress struct {
+			Status         string `json:"status"`
+			ID             string `json:"id"`
+			ProgressDetail struct {
+				Current int64 `json:"current"`
+				Total   int64 `json:"total"`
+			} `json:"progressDetail"`
+		}
+
+		if err := decoder.Decode(&progress); err != nil {
+			if err == io.EOF {
+				return nil
+			}
+			return err
+		}
+
+		if err := conn.WriteJSON(map[string]interface{}{
+			"layer_id": progress.ID,
+			"current":  progress.ProgressDetail.Current,
+			"total":    progress.ProgressDetail.Total,
+			"status":   progress.Status,
+		}); err != nil {
+			return err
+		}
+	}
+}
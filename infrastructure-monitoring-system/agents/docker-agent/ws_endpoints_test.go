@@ -0,0 +1,83 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// Test that agentContext falls back to context.Background() when the agent
+// hasn't been started (da.ctx is nil), instead of deriving from a nil
+// context and panicking.
+func TestAgentContext(t *testing.T) {
+	agent := &DockerAgent{}
+	if agent.agentContext() == nil {
+		t.Fatal("Expected a non-nil fallback context")
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	agent.ctx = ctx
+	if agent.agentContext() != ctx {
+		t.Error("Expected agentContext to return the agent's own context once set")
+	}
+}
+
+// Test that queryRegistryAuthCommand only wraps a credential_id when one is
+// present in the query string.
+func TestQueryRegistryAuthCommand(t *testing.T) {
+	req := httptest.NewRequest(http.MethodGet, "/ws/pull?image=nginx&credential_id=registry-1", nil)
+	command := queryRegistryAuthCommand(req)
+	auth, ok := command["registry_auth"].(map[string]interface{})
+	if !ok || auth["credential_id"] != "registry-1" {
+		t.Errorf("Expected registry_auth.credential_id 'registry-1', got %v", command)
+	}
+
+	req = httptest.NewRequest(http.MethodGet, "/ws/pull?image=nginx", nil)
+	if command := queryRegistryAuthCommand(req); len(command) != 0 {
+		t.Errorf("Expected an empty command when credential_id is absent, got %v", command)
+	}
+}
+
+// Test that handleLogsWebSocket rejects a request with no container_id in
+// the path before attempting to upgrade the connection.
+func TestHandleLogsWebSocketRequiresContainerID(t *testing.T) {
+	agent := &DockerAgent{config: &Config{}, dockerClient: &MockDockerClient{}, streamSem: make(chan struct{}, 1)}
+
+	req := httptest.NewRequest(http.MethodGet, "/ws/logs/", nil)
+	rec := httptest.NewRecorder()
+	agent.handleLogsWebSocket(rec, req)
+
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("Expected 400 for a missing container_id, got %d", rec.Code)
+	}
+}
+
+// Test that handlePullWebSocket rejects a request with no image query param
+// before attempting to upgrade the connection.
+func TestHandlePullWebSocketRequiresImage(t *testing.T) {
+	agent := &DockerAgent{config: &Config{}, dockerClient: &MockDockerClient{}}
+
+	req := httptest.NewRequest(http.MethodGet, "/ws/pull", nil)
+	rec := httptest.NewRecorder()
+	agent.handlePullWebSocket(rec, req)
+
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("Expected 400 for a missing image, got %d", rec.Code)
+	}
+}
+
+// Test that handlePullWebSocket rejects an unknown credential_id with a 400
+// before pulling, instead of silently pulling unauthenticated.
+func TestHandlePullWebSocketUnknownCredential(t *testing.T) {
+	agent := &DockerAgent{config: &Config{CredentialStore: map[string]RegistryCredential{}}, dockerClient: &MockDockerClient{}}
+
+	req := httptest.NewRequest(http.MethodGet, "/ws/pull?image=nginx&credential_id=missing", nil)
+	rec := httptest.NewRecorder()
+	agent.handlePullWebSocket(rec, req)
+
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("Expected 400 for an unknown credential_id, got %d", rec.Code)
+	}
+}